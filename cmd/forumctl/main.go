@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"technopark_db_forum/internal/models"
+	userRepository "technopark_db_forum/internal/users/repository"
+	userUsecase "technopark_db_forum/internal/users/usecase"
+)
+
+const (
+	defaultDBConfig = "host=localhost port=5432 dbname=dev sslmode=disable"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"export": {
+		usage: "export -nickname NAME [-format ndjson|zip] [-out FILE]",
+		run:   runExport,
+	},
+	"import": {
+		usage: "import -file FILE [-format csv|ndjson] [-dry-run]",
+		run:   runImport,
+	},
+	"role": {
+		usage: "role -nickname NAME [-role admin|user]",
+		run:   runRole,
+	},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: forumctl <command> [flags]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  forumctl %s\n", commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "forumctl %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func newUserUsecase(dsn string) (userUsecase.UsersUsecase, error) {
+	usersRepo, err := userRepository.NewPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return userUsecase.NewUserUsecase(usersRepo), nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dsn := fs.String("db", defaultDBConfig, "postgres connection string")
+	nickname := fs.String("nickname", "", "user to export")
+	format := fs.String("format", models.ExportFormatNDJSON, "bundle format: ndjson or zip")
+	out := fs.String("out", "", "output file, stdout when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *nickname == "" {
+		return fmt.Errorf("-nickname is required")
+	}
+
+	users, err := newUserUsecase(*dsn)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return users.DumpUser(*nickname, *format, w)
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dsn := fs.String("db", defaultDBConfig, "postgres connection string")
+	file := fs.String("file", "", "CSV or NDJSON file with users")
+	format := fs.String("format", "", "input format, guessed from the file extension when empty")
+	dryRun := fs.Bool("dry-run", false, "validate and report conflicts without storing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *format == "" {
+		*format = models.ImportFormatNDJSON
+		if filepath.Ext(*file) == ".csv" {
+			*format = models.ImportFormatCSV
+		}
+	}
+
+	users, err := newUserUsecase(*dsn)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The result is printed even when the import stopped early, since the
+	// batches before the error are stored.
+	result, err := users.ImportUsers(f, *format, *dryRun)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(result); encErr != nil {
+		return encErr
+	}
+	return err
+}
+
+// runRole sets a user's global role directly in the database. It is how the
+// first admin is made, since the API only lets admins grant roles.
+func runRole(args []string) error {
+	fs := flag.NewFlagSet("role", flag.ExitOnError)
+	dsn := fs.String("db", defaultDBConfig, "postgres connection string")
+	nickname := fs.String("nickname", "", "user to change")
+	role := fs.String("role", models.RoleAdmin, "new role: admin or user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *nickname == "" {
+		return fmt.Errorf("-nickname is required")
+	}
+
+	users, err := newUserUsecase(*dsn)
+	if err != nil {
+		return err
+	}
+
+	res, err := users.GrantRole(*nickname, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", res.Nickname, res.Role)
+	return nil
+}
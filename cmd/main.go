@@ -1,7 +1,9 @@
 package main
 
 import (
+	"os"
 	"technopark_db_forum/internal/app"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -13,6 +15,9 @@ const (
 func main() {
 	e := echo.New()
 	s := app.New(e)
+	if ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL")); err == nil {
+		s.IdempotencyTTL = ttl
+	}
 
 	if err := s.Start(":8080", defaultDBConfig); err != nil {
 		s.Echo.Logger.Error("server errors: %s", err)
@@ -1,53 +1,97 @@
 CREATE EXTENSION IF NOT EXISTS citext;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
 
 CREATE TABLE IF NOT EXISTS users (
     nickname citext PRIMARY KEY,
     fullname VARCHAR NOT NULL,
     email citext NOT NULL UNIQUE,
-    about VARCHAR NOT NULL
+    about VARCHAR NOT NULL,
+    role VARCHAR NOT NULL DEFAULT 'user' CHECK ( role IN ('admin', 'user') ),
+    version BIGINT NOT NULL DEFAULT 1
+);
+
+-- Old nicknames keep resolving to the renamed user until expires.
+CREATE TABLE IF NOT EXISTS user_redirects (
+    old_nickname citext PRIMARY KEY,
+    nickname citext NOT NULL REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS forums (
     slug citext PRIMARY KEY,
     title VARCHAR NOT NULL,
-    user_nick citext REFERENCES users(nickname),
+    user_nick citext REFERENCES users(nickname) ON UPDATE CASCADE,
     posts BIGINT DEFAULT 0,
-    threads BIGINT DEFAULT 0
+    threads BIGINT DEFAULT 0,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    last_activity TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    parent citext REFERENCES forums(slug) ON UPDATE CASCADE ON DELETE SET NULL,
+    category VARCHAR NOT NULL DEFAULT '',
+    -- posts and threads of the forum together with all of its subforums
+    total_posts BIGINT NOT NULL DEFAULT 0,
+    total_threads BIGINT NOT NULL DEFAULT 0,
+    visibility VARCHAR NOT NULL DEFAULT 'public' CHECK ( visibility IN ('public', 'members') ),
+    posting VARCHAR NOT NULL DEFAULT 'open' CHECK ( posting IN ('open', 'read_only', 'moderated') ),
+    thread_creation VARCHAR NOT NULL DEFAULT 'anyone' CHECK ( thread_creation IN ('anyone', 'members', 'moderators') )
 );
 
+CREATE INDEX IF NOT EXISTS forums_parent_idx ON forums (parent);
+
 CREATE TABLE IF NOT EXISTS forum_users (
     forum citext REFERENCES forums(slug) ON DELETE CASCADE,
-    user_nick citext REFERENCES users(nickname) ON DELETE CASCADE,
+    user_nick citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    threads BIGINT NOT NULL DEFAULT 0,
+    posts BIGINT NOT NULL DEFAULT 0,
     PRIMARY KEY (user_nick, forum)
 );
 
 CREATE TABLE IF NOT EXISTS threads (
     id BIGSERIAL NOT NULL PRIMARY KEY,
     title VARCHAR NOT NULL,
-    author citext NOT NULL REFERENCES users(nickname),
+    author citext NOT NULL REFERENCES users(nickname) ON UPDATE CASCADE,
     forum citext NOT NULL REFERENCES forums(slug) ON DELETE CASCADE,
     message TEXT NOT NULL,
-    slug citext NOT NULL,
+    -- generated from the title when none is given, NULL only for old threads
+    slug citext,
     votes INT NOT NULL DEFAULT 0,
     post_tree BIGINT[] DEFAULT '{}',
-    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    version BIGINT NOT NULL DEFAULT 1,
+    -- locked threads take no posts or votes, closed ones are also frozen for edits
+    status VARCHAR NOT NULL DEFAULT 'open' CHECK ( status IN ('open', 'locked', 'closed') ),
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    announcement BOOLEAN NOT NULL DEFAULT FALSE,
+    -- sort keys of the forum thread listings, kept up to date by triggers
+    replies BIGINT NOT NULL DEFAULT 0,
+    last_post_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    hot DOUBLE PRECISION NOT NULL DEFAULT 0,
+    -- set by votes and posts, cleared when the trending scores are recomputed
+    rank_dirty BOOLEAN NOT NULL DEFAULT TRUE,
+    ranked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT '-infinity'
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS threads_slug_key ON threads (slug) WHERE slug IS NOT NULL;
+CREATE INDEX IF NOT EXISTS threads_pinned_idx ON threads (forum) WHERE pinned;
+CREATE INDEX IF NOT EXISTS threads_announcement_idx ON threads (created) WHERE announcement;
+
 CREATE TABLE IF NOT EXISTS posts (
     id BIGSERIAL NOT NULL PRIMARY KEY,
     parent BIGINT DEFAULT 0,
-    author citext REFERENCES users(nickname),
+    author citext REFERENCES users(nickname) ON UPDATE CASCADE,
     forum citext REFERENCES forums(slug) ON DELETE CASCADE,
     thread BIGSERIAL REFERENCES threads(id) ON DELETE CASCADE,
     is_edited BOOLEAN DEFAULT FALSE,
     message TEXT NOT NULL,
     created TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
-    path BIGINT[] DEFAULT ARRAY []::BIGINT[]
+    path BIGINT[] DEFAULT ARRAY []::BIGINT[],
+    version BIGINT NOT NULL DEFAULT 1
 );
 
 CREATE TABLE IF NOT EXISTS votes
 (
-    nickname  citext REFERENCES users (nickname) ON DELETE NO ACTION NOT NULL,
+    nickname  citext REFERENCES users (nickname) ON UPDATE CASCADE ON DELETE NO ACTION NOT NULL,
     thread BIGSERIAL REFERENCES threads (id) ON DELETE CASCADE    NOT NULL,
     voice     SMALLINT CHECK ( voice BETWEEN -1 AND 1 )              NOT NULL,
     PRIMARY KEY (nickname, thread)
@@ -58,7 +102,7 @@ CREATE TABLE IF NOT EXISTS votes
 CREATE OR REPLACE FUNCTION insert_trigger_thread_votes() RETURNS TRIGGER AS
 $$
 BEGIN
-    UPDATE threads SET votes = votes + new.voice WHERE id = new.thread;
+    UPDATE threads SET votes = votes + new.voice, rank_dirty = TRUE WHERE id = new.thread;
     RETURN new;
 END;
 $$ LANGUAGE plpgsql;
@@ -66,11 +110,20 @@ $$ LANGUAGE plpgsql;
 CREATE OR REPLACE FUNCTION update_trigger_thread_votes() RETURNS TRIGGER AS
 $$
 BEGIN
-    UPDATE threads SET votes = votes + new.voice - old.voice WHERE id = new.thread;
+    UPDATE threads SET votes = votes + new.voice - old.voice, rank_dirty = TRUE WHERE id = new.thread;
     RETURN new;
 END;
 $$ LANGUAGE plpgsql;
 
+-- Retracting a vote takes its voice back
+CREATE OR REPLACE FUNCTION delete_trigger_thread_votes() RETURNS TRIGGER AS
+$$
+BEGIN
+    UPDATE threads SET votes = votes - old.voice, rank_dirty = TRUE WHERE id = old.thread;
+    RETURN old;
+END;
+$$ LANGUAGE plpgsql;
+
 CREATE TRIGGER insert_trigger_thread_votes
     AFTER INSERT
     ON votes
@@ -83,6 +136,12 @@ CREATE TRIGGER update_trigger_thread_votes
     FOR EACH ROW
 EXECUTE PROCEDURE update_trigger_thread_votes();
 
+CREATE TRIGGER delete_trigger_thread_votes
+    AFTER DELETE
+    ON votes
+    FOR EACH ROW
+EXECUTE PROCEDURE delete_trigger_thread_votes();
+
 CREATE OR REPLACE FUNCTION update_path_trigger() RETURNS TRIGGER AS
 $$
 BEGIN
@@ -97,11 +156,28 @@ CREATE TRIGGER update_path_trigger
     FOR EACH ROW
 EXECUTE PROCEDURE update_path_trigger();
 
+-- The forum itself followed by all of its ancestors
+CREATE OR REPLACE FUNCTION forum_ancestors(start citext) RETURNS SETOF citext AS
+$$
+    WITH RECURSIVE chain(slug, parent) AS (
+        SELECT slug, parent FROM forums WHERE slug = start
+        UNION
+        SELECT forums.slug, forums.parent FROM forums JOIN chain ON forums.slug = chain.parent
+    )
+    SELECT slug FROM chain;
+$$ LANGUAGE sql STABLE;
+
 -- Functions and triggers for counting posts and threads in forums
 CREATE OR REPLACE FUNCTION insert_trigger_forum_posts() RETURNS TRIGGER AS
 $$
+DECLARE
+    parent_slug citext;
 BEGIN
-    UPDATE forums SET posts = posts + 1 WHERE slug = new.forum;
+    UPDATE forums SET posts = posts + 1, total_posts = total_posts + 1, last_activity = GREATEST(last_activity, new.created)
+    WHERE slug = new.forum RETURNING parent INTO parent_slug;
+    IF parent_slug IS NOT NULL THEN
+        UPDATE forums SET total_posts = total_posts + 1 WHERE slug IN (SELECT forum_ancestors(parent_slug));
+    END IF;
     RETURN new;
 END;
 $$ LANGUAGE plpgsql;
@@ -114,8 +190,14 @@ EXECUTE PROCEDURE insert_trigger_forum_posts();
 
 CREATE OR REPLACE FUNCTION insert_trigger_forum_threads() RETURNS TRIGGER AS
 $$
+DECLARE
+    parent_slug citext;
 BEGIN
-    UPDATE forums SET threads = threads + 1 WHERE slug = new.forum;
+    UPDATE forums SET threads = threads + 1, total_threads = total_threads + 1, last_activity = GREATEST(last_activity, new.created)
+    WHERE slug = new.forum RETURNING parent INTO parent_slug;
+    IF parent_slug IS NOT NULL THEN
+        UPDATE forums SET total_threads = total_threads + 1 WHERE slug IN (SELECT forum_ancestors(parent_slug));
+    END IF;
     RETURN new;
 END;
 $$ LANGUAGE plpgsql;
@@ -124,4 +206,638 @@ CREATE TRIGGER insert_trigger_forum_threads
     AFTER INSERT
     ON threads
     FOR EACH ROW
-EXECUTE PROCEDURE insert_trigger_forum_threads();
\ No newline at end of file
+EXECUTE PROCEDURE insert_trigger_forum_threads();
+
+-- Per-user statistics, maintained incrementally by triggers
+CREATE TABLE IF NOT EXISTS user_stats (
+    nickname citext PRIMARY KEY REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    threads BIGINT NOT NULL DEFAULT 0,
+    posts BIGINT NOT NULL DEFAULT 0,
+    forums BIGINT NOT NULL DEFAULT 0,
+    karma BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS user_stats_karma_idx ON user_stats (karma, nickname);
+
+CREATE OR REPLACE FUNCTION insert_trigger_user_stats() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO user_stats (nickname) VALUES (new.nickname) ON CONFLICT DO NOTHING;
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER insert_trigger_user_stats
+    AFTER INSERT
+    ON users
+    FOR EACH ROW
+EXECUTE PROCEDURE insert_trigger_user_stats();
+
+CREATE OR REPLACE FUNCTION trigger_user_stats_threads() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'INSERT' THEN
+        UPDATE user_stats SET threads = threads + 1 WHERE nickname = new.author;
+        RETURN new;
+    END IF;
+    -- Votes removed by the cascade can no longer see their thread, so the
+    -- thread takes its whole score away from the author here.
+    UPDATE user_stats SET threads = threads - 1, karma = karma - old.votes WHERE nickname = old.author;
+    RETURN old;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_user_stats_threads
+    AFTER INSERT OR DELETE
+    ON threads
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_user_stats_threads();
+
+CREATE OR REPLACE FUNCTION trigger_user_stats_posts() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'INSERT' THEN
+        UPDATE user_stats SET posts = posts + 1 WHERE nickname = new.author;
+        RETURN new;
+    END IF;
+    UPDATE user_stats SET posts = posts - 1 WHERE nickname = old.author;
+    RETURN old;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_user_stats_posts
+    AFTER INSERT OR DELETE
+    ON posts
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_user_stats_posts();
+
+CREATE OR REPLACE FUNCTION trigger_user_stats_forums() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'INSERT' THEN
+        UPDATE user_stats SET forums = forums + 1 WHERE nickname = new.user_nick;
+        RETURN new;
+    END IF;
+    UPDATE user_stats SET forums = forums - 1 WHERE nickname = old.user_nick;
+    RETURN old;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_user_stats_forums
+    AFTER INSERT OR DELETE
+    ON forum_users
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_user_stats_forums();
+
+CREATE OR REPLACE FUNCTION trigger_user_stats_karma() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'INSERT' THEN
+        UPDATE user_stats SET karma = karma + new.voice
+        WHERE nickname = (SELECT author FROM threads WHERE id = new.thread);
+        RETURN new;
+    ELSIF tg_op = 'UPDATE' THEN
+        UPDATE user_stats SET karma = karma + new.voice - old.voice
+        WHERE nickname = (SELECT author FROM threads WHERE id = new.thread);
+        RETURN new;
+    END IF;
+    UPDATE user_stats SET karma = karma - old.voice
+    WHERE nickname = (SELECT author FROM threads WHERE id = old.thread);
+    RETURN old;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_user_stats_karma
+    AFTER INSERT OR UPDATE OR DELETE
+    ON votes
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_user_stats_karma();
+
+
+-- Follow relations feeding the personalized activity feed
+CREATE TABLE IF NOT EXISTS user_follows (
+    follower citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    followee citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (follower, followee),
+    CHECK ( follower <> followee )
+);
+
+CREATE TABLE IF NOT EXISTS forum_follows (
+    follower citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    forum citext REFERENCES forums(slug) ON DELETE CASCADE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (follower, forum)
+);
+
+-- The feed is assembled on read: each followed source is scanned backwards
+-- from the cursor through these indexes, so a popular forum costs the same
+-- no matter how many followers it has.
+CREATE INDEX IF NOT EXISTS threads_forum_created_idx ON threads (forum, created, id);
+CREATE INDEX IF NOT EXISTS threads_author_created_idx ON threads (author, created, id);
+CREATE INDEX IF NOT EXISTS posts_forum_created_idx ON posts (forum, created, id);
+CREATE INDEX IF NOT EXISTS posts_author_created_idx ON posts (author, created, id);
+
+
+-- Mutes hide an author's content from the viewer; blocks also stop the
+-- target from replying to the blocker's posts.
+CREATE TABLE IF NOT EXISTS user_relations (
+    nickname citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    target citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    kind VARCHAR NOT NULL CHECK ( kind IN ('mute', 'block') ),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (nickname, kind, target),
+    CHECK ( nickname <> target )
+);
+
+
+-- Per-forum moderation: moderators and users banned from writing
+CREATE TABLE IF NOT EXISTS forum_moderators (
+    forum citext REFERENCES forums(slug) ON DELETE CASCADE,
+    nickname citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (forum, nickname)
+);
+
+CREATE TABLE IF NOT EXISTS forum_bans (
+    forum citext REFERENCES forums(slug) ON DELETE CASCADE,
+    nickname citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (forum, nickname)
+);
+
+
+-- First responses of create requests, replayed for retries with the same
+-- Idempotency-Key. status stays NULL while the first request is in flight.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR PRIMARY KEY,
+    request_hash VARCHAR NOT NULL,
+    status INT,
+    content_type VARCHAR,
+    body BYTEA,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_idx ON idempotency_keys (created);
+
+
+-- Record of destructive moderation actions
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE SET NULL,
+    action VARCHAR NOT NULL,
+    target VARCHAR NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+
+-- Forum listing: keyset indexes for every sort order and trigram indexes for
+-- substring search on title and slug
+CREATE INDEX IF NOT EXISTS forums_title_idx ON forums (title, slug);
+CREATE INDEX IF NOT EXISTS forums_posts_idx ON forums (posts, slug);
+CREATE INDEX IF NOT EXISTS forums_threads_idx ON forums (threads, slug);
+CREATE INDEX IF NOT EXISTS forums_activity_idx ON forums (last_activity, slug);
+CREATE INDEX IF NOT EXISTS forums_title_trgm_idx ON forums USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS forums_slug_trgm_idx ON forums USING gin ((slug::text) gin_trgm_ops);
+
+-- A deleted subforum no longer counts towards its ancestors; its own
+-- subforums are detached by ON DELETE SET NULL and take their totals along.
+CREATE OR REPLACE FUNCTION delete_trigger_forum_totals() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF old.parent IS NOT NULL THEN
+        UPDATE forums SET total_posts = total_posts - old.total_posts, total_threads = total_threads - old.total_threads
+        WHERE slug IN (SELECT forum_ancestors(old.parent));
+    END IF;
+    RETURN old;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER delete_trigger_forum_totals
+    AFTER DELETE
+    ON forums
+    FOR EACH ROW
+EXECUTE PROCEDURE delete_trigger_forum_totals();
+
+-- Explicit forum membership, separate from forum_users participation.
+-- Members-only forums go through an invite or a join request first.
+CREATE TABLE IF NOT EXISTS forum_members (
+    forum citext REFERENCES forums(slug) ON DELETE CASCADE,
+    nickname citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    state VARCHAR NOT NULL CHECK ( state IN ('pending', 'invited', 'active') ),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (forum, nickname)
+);
+
+-- forum_users participation is recorded by the content triggers themselves,
+-- together with when and how much each user wrote in the forum
+CREATE OR REPLACE FUNCTION trigger_forum_users_threads() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO forum_users (forum, user_nick, first_seen, last_seen, threads)
+    VALUES (new.forum, new.author, new.created, new.created, 1)
+    ON CONFLICT (user_nick, forum) DO UPDATE SET
+        threads = forum_users.threads + 1,
+        first_seen = LEAST(forum_users.first_seen, excluded.first_seen),
+        last_seen = GREATEST(forum_users.last_seen, excluded.last_seen);
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_forum_users_threads
+    AFTER INSERT
+    ON threads
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_forum_users_threads();
+
+CREATE OR REPLACE FUNCTION trigger_forum_users_posts() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO forum_users (forum, user_nick, first_seen, last_seen, posts)
+    VALUES (new.forum, new.author, new.created, new.created, 1)
+    ON CONFLICT (user_nick, forum) DO UPDATE SET
+        posts = forum_users.posts + 1,
+        first_seen = LEAST(forum_users.first_seen, excluded.first_seen),
+        last_seen = GREATEST(forum_users.last_seen, excluded.last_seen);
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_forum_users_posts
+    AFTER INSERT
+    ON posts
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_forum_users_posts();
+
+CREATE INDEX IF NOT EXISTS forum_users_posts_idx ON forum_users (forum, posts, user_nick);
+CREATE INDEX IF NOT EXISTS forum_users_last_seen_idx ON forum_users (forum, last_seen, user_nick);
+
+-- Hourly activity rollups behind the forum and service stats. Longer buckets
+-- are summed from the hourly rows; active authors are kept per hour so they
+-- can be counted distinctly over any bucket.
+CREATE TABLE IF NOT EXISTS forum_activity_hourly (
+    forum citext REFERENCES forums(slug) ON DELETE CASCADE,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    threads BIGINT NOT NULL DEFAULT 0,
+    posts BIGINT NOT NULL DEFAULT 0,
+    votes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (forum, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS forum_activity_hourly_bucket_idx ON forum_activity_hourly (bucket);
+
+CREATE TABLE IF NOT EXISTS forum_activity_authors (
+    forum citext REFERENCES forums(slug) ON DELETE CASCADE,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    author citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (forum, bucket, author)
+);
+
+CREATE INDEX IF NOT EXISTS forum_activity_authors_bucket_idx ON forum_activity_authors (bucket);
+
+CREATE OR REPLACE FUNCTION trigger_forum_activity() RETURNS TRIGGER AS
+$$
+DECLARE
+    hour TIMESTAMP WITH TIME ZONE;
+BEGIN
+    hour := date_trunc('hour', new.created, 'UTC');
+    IF TG_TABLE_NAME = 'threads' THEN
+        INSERT INTO forum_activity_hourly (forum, bucket, threads) VALUES (new.forum, hour, 1)
+        ON CONFLICT (forum, bucket) DO UPDATE SET threads = forum_activity_hourly.threads + 1;
+    ELSE
+        INSERT INTO forum_activity_hourly (forum, bucket, posts) VALUES (new.forum, hour, 1)
+        ON CONFLICT (forum, bucket) DO UPDATE SET posts = forum_activity_hourly.posts + 1;
+    END IF;
+    INSERT INTO forum_activity_authors (forum, bucket, author) VALUES (new.forum, hour, new.author)
+    ON CONFLICT DO NOTHING;
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_forum_activity_threads
+    AFTER INSERT
+    ON threads
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_forum_activity();
+
+CREATE TRIGGER trigger_forum_activity_posts
+    AFTER INSERT
+    ON posts
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_forum_activity();
+
+-- Votes carry no timestamp, so a vote counts in the hour it was cast
+CREATE OR REPLACE FUNCTION trigger_forum_activity_votes() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO forum_activity_hourly (forum, bucket, votes)
+    SELECT forum, date_trunc('hour', NOW(), 'UTC'), 1 FROM threads WHERE id = new.thread
+    ON CONFLICT (forum, bucket) DO UPDATE SET votes = forum_activity_hourly.votes + 1;
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_forum_activity_votes
+    AFTER INSERT
+    ON votes
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_forum_activity_votes();
+
+-- Thread tags. forum and created copy the thread's so tag-filtered forum
+-- listings are served by one index in thread order.
+CREATE TABLE IF NOT EXISTS thread_tags (
+    thread BIGINT REFERENCES threads(id) ON DELETE CASCADE,
+    tag citext NOT NULL,
+    forum citext NOT NULL REFERENCES forums(slug) ON DELETE CASCADE,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (thread, tag)
+);
+
+CREATE INDEX IF NOT EXISTS thread_tags_forum_tag_idx ON thread_tags (forum, tag, created, thread);
+CREATE INDEX IF NOT EXISTS thread_tags_tag_idx ON thread_tags (tag);
+
+-- Thread sort keys. hot grows with the logarithm of the score and linearly
+-- with the creation time, so a thread needs ten times the votes to outrank
+-- one posted 12.5 hours later. It never changes with time alone; the decaying
+-- trending score is the separate "rising" ranker in thread_rankings.
+CREATE OR REPLACE FUNCTION thread_hot(votes BIGINT, created TIMESTAMP WITH TIME ZONE) RETURNS DOUBLE PRECISION AS
+$$
+    SELECT (sign(votes) * log(greatest(abs(votes), 1)) + extract(EPOCH FROM created) / 45000)::DOUBLE PRECISION;
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION trigger_thread_sort_keys() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'INSERT' THEN
+        new.last_post_at = new.created;
+    END IF;
+    new.hot = thread_hot(new.votes, new.created);
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_thread_sort_keys
+    BEFORE INSERT OR UPDATE OF votes, created
+    ON threads
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_thread_sort_keys();
+
+CREATE OR REPLACE FUNCTION insert_trigger_thread_replies() RETURNS TRIGGER AS
+$$
+BEGIN
+    UPDATE threads SET replies = replies + 1, last_post_at = GREATEST(last_post_at, new.created), rank_dirty = TRUE
+    WHERE id = new.thread;
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER insert_trigger_thread_replies
+    AFTER INSERT
+    ON posts
+    FOR EACH ROW
+EXECUTE PROCEDURE insert_trigger_thread_replies();
+
+CREATE INDEX IF NOT EXISTS threads_forum_votes_idx ON threads (forum, votes, id);
+CREATE INDEX IF NOT EXISTS threads_forum_last_post_idx ON threads (forum, last_post_at, id);
+CREATE INDEX IF NOT EXISTS threads_forum_replies_idx ON threads (forum, replies, id);
+CREATE INDEX IF NOT EXISTS threads_forum_hot_idx ON threads (forum, hot, id);
+
+-- Trending scores, one row per thread and ranking formula, recomputed by the
+-- ranking job from the thread's votes, replies and age.
+CREATE TABLE IF NOT EXISTS thread_rankings (
+    thread BIGINT REFERENCES threads(id) ON DELETE CASCADE,
+    ranker VARCHAR NOT NULL,
+    forum citext NOT NULL REFERENCES forums(slug) ON DELETE CASCADE,
+    score DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (thread, ranker)
+);
+
+-- The trending ranker was called hot before it was told apart from sort=hot.
+UPDATE thread_rankings SET ranker = 'rising' WHERE ranker = 'hot';
+
+CREATE INDEX IF NOT EXISTS thread_rankings_forum_idx ON thread_rankings (forum, ranker, score, thread);
+CREATE INDEX IF NOT EXISTS thread_rankings_score_idx ON thread_rankings (ranker, score, thread);
+CREATE INDEX IF NOT EXISTS threads_rank_dirty_idx ON threads (id) WHERE rank_dirty;
+CREATE INDEX IF NOT EXISTS threads_last_post_idx ON threads (last_post_at);
+CREATE INDEX IF NOT EXISTS posts_thread_created_idx ON posts (thread, created);
+CREATE INDEX IF NOT EXISTS votes_thread_idx ON votes (thread, nickname);
+
+-- Deleting and moving content. forum_shift_counts moves a forum's own
+-- counters and the totals of its whole ancestor chain by the given amounts;
+-- it does nothing for a forum that is itself being deleted, whose ancestors
+-- are settled by delete_trigger_forum_totals.
+CREATE OR REPLACE FUNCTION forum_shift_counts(target citext, thread_count BIGINT, post_count BIGINT) RETURNS VOID AS
+$$
+DECLARE
+    parent_slug citext;
+BEGIN
+    UPDATE forums SET threads = threads + thread_count, posts = posts + post_count,
+        total_threads = total_threads + thread_count, total_posts = total_posts + post_count
+    WHERE slug = target RETURNING parent INTO parent_slug;
+    IF parent_slug IS NOT NULL THEN
+        UPDATE forums SET total_threads = total_threads + thread_count, total_posts = total_posts + post_count
+        WHERE slug IN (SELECT forum_ancestors(parent_slug));
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- forum_users_shift moves a user's participation counters in a forum; a user
+-- left with nothing in the forum is no longer one of its users.
+CREATE OR REPLACE FUNCTION forum_users_shift(target citext, nickname citext, thread_count BIGINT, post_count BIGINT,
+    seen TIMESTAMP WITH TIME ZONE) RETURNS VOID AS
+$$
+BEGIN
+    IF thread_count > 0 OR post_count > 0 THEN
+        INSERT INTO forum_users (forum, user_nick, first_seen, last_seen, threads, posts)
+        VALUES (target, nickname, seen, seen, thread_count, post_count)
+        ON CONFLICT (user_nick, forum) DO UPDATE SET
+            threads = forum_users.threads + excluded.threads,
+            posts = forum_users.posts + excluded.posts,
+            first_seen = LEAST(forum_users.first_seen, excluded.first_seen),
+            last_seen = GREATEST(forum_users.last_seen, excluded.last_seen);
+        RETURN;
+    END IF;
+    UPDATE forum_users SET threads = threads + thread_count, posts = posts + post_count
+    WHERE forum = target AND user_nick = nickname;
+    DELETE FROM forum_users WHERE forum = target AND user_nick = nickname AND threads <= 0 AND posts <= 0;
+END;
+$$ LANGUAGE plpgsql;
+
+-- forum_activity_shift moves the hourly rollup of a thread or post created
+-- at seen by author. An author left with nothing in that hour of the forum is
+-- no longer counted as active there. Votes keep the hour they were cast in.
+CREATE OR REPLACE FUNCTION forum_activity_shift(target citext, nickname citext, seen TIMESTAMP WITH TIME ZONE,
+    thread_count BIGINT, post_count BIGINT) RETURNS VOID AS
+$$
+DECLARE
+    hour TIMESTAMP WITH TIME ZONE;
+BEGIN
+    hour := date_trunc('hour', seen, 'UTC');
+    IF thread_count > 0 OR post_count > 0 THEN
+        INSERT INTO forum_activity_hourly (forum, bucket, threads, posts) VALUES (target, hour, thread_count, post_count)
+        ON CONFLICT (forum, bucket) DO UPDATE SET
+            threads = forum_activity_hourly.threads + excluded.threads,
+            posts = forum_activity_hourly.posts + excluded.posts;
+        INSERT INTO forum_activity_authors (forum, bucket, author) VALUES (target, hour, nickname)
+        ON CONFLICT DO NOTHING;
+        RETURN;
+    END IF;
+    UPDATE forum_activity_hourly SET threads = threads + thread_count, posts = posts + post_count
+    WHERE forum = target AND bucket = hour;
+    IF NOT EXISTS (SELECT 1 FROM threads WHERE forum = target AND author = nickname
+                   AND created >= hour AND created < hour + INTERVAL '1 hour')
+       AND NOT EXISTS (SELECT 1 FROM posts WHERE forum = target AND author = nickname
+                       AND created >= hour AND created < hour + INTERVAL '1 hour') THEN
+        DELETE FROM forum_activity_authors WHERE forum = target AND bucket = hour AND author = nickname;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- A moved thread takes its posts, tags and scores along; the posts trigger
+-- below moves their counters.
+CREATE OR REPLACE FUNCTION trigger_thread_moved() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'DELETE' THEN
+        PERFORM forum_shift_counts(old.forum, -1, 0);
+        PERFORM forum_users_shift(old.forum, old.author, -1, 0, old.created);
+        PERFORM forum_activity_shift(old.forum, old.author, old.created, -1, 0);
+        RETURN old;
+    END IF;
+
+    PERFORM forum_shift_counts(old.forum, -1, 0);
+    PERFORM forum_shift_counts(new.forum, 1, 0);
+    PERFORM forum_users_shift(old.forum, old.author, -1, 0, old.created);
+    PERFORM forum_users_shift(new.forum, new.author, 1, 0, new.created);
+    PERFORM forum_activity_shift(old.forum, old.author, old.created, -1, 0);
+    PERFORM forum_activity_shift(new.forum, new.author, new.created, 1, 0);
+    UPDATE posts SET forum = new.forum WHERE thread = new.id;
+    UPDATE thread_tags SET forum = new.forum WHERE thread = new.id;
+    UPDATE thread_rankings SET forum = new.forum WHERE thread = new.id;
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER delete_trigger_thread_moved
+    AFTER DELETE
+    ON threads
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_thread_moved();
+
+CREATE TRIGGER update_trigger_thread_moved
+    AFTER UPDATE OF forum
+    ON threads
+    FOR EACH ROW
+    WHEN (old.forum IS DISTINCT FROM new.forum)
+EXECUTE PROCEDURE trigger_thread_moved();
+
+-- thread_last_post is the time of the latest post left in a thread, or its
+-- creation time once it has none
+CREATE OR REPLACE FUNCTION thread_last_post(thread_id BIGINT) RETURNS TIMESTAMP WITH TIME ZONE AS
+$$
+    SELECT COALESCE((SELECT MAX(created) FROM posts WHERE thread = thread_id), (SELECT created FROM threads WHERE id = thread_id));
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION trigger_post_moved() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF tg_op = 'DELETE' THEN
+        PERFORM forum_shift_counts(old.forum, 0, -1);
+        PERFORM forum_users_shift(old.forum, old.author, 0, -1, old.created);
+        PERFORM forum_activity_shift(old.forum, old.author, old.created, 0, -1);
+        UPDATE threads SET replies = replies - 1, rank_dirty = TRUE,
+            last_post_at = CASE WHEN old.created >= last_post_at THEN thread_last_post(old.thread) ELSE last_post_at END
+        WHERE id = old.thread;
+        RETURN old;
+    END IF;
+
+    IF old.forum IS DISTINCT FROM new.forum THEN
+        PERFORM forum_shift_counts(old.forum, 0, -1);
+        PERFORM forum_shift_counts(new.forum, 0, 1);
+        PERFORM forum_users_shift(old.forum, old.author, 0, -1, old.created);
+        PERFORM forum_users_shift(new.forum, new.author, 0, 1, new.created);
+        PERFORM forum_activity_shift(old.forum, old.author, old.created, 0, -1);
+        PERFORM forum_activity_shift(new.forum, new.author, new.created, 0, 1);
+    END IF;
+    IF old.thread <> new.thread THEN
+        UPDATE threads SET replies = replies - 1, rank_dirty = TRUE,
+            last_post_at = CASE WHEN old.created >= last_post_at THEN thread_last_post(old.thread) ELSE last_post_at END
+        WHERE id = old.thread;
+        UPDATE threads SET replies = replies + 1, last_post_at = GREATEST(last_post_at, new.created), rank_dirty = TRUE
+        WHERE id = new.thread;
+    END IF;
+    RETURN new;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER delete_trigger_post_moved
+    AFTER DELETE
+    ON posts
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_post_moved();
+
+CREATE TRIGGER update_trigger_post_moved
+    AFTER UPDATE OF forum, thread
+    ON posts
+    FOR EACH ROW
+    WHEN (old.forum IS DISTINCT FROM new.forum OR old.thread <> new.thread)
+EXECUTE PROCEDURE trigger_post_moved();
+
+-- Edit history. A row is the content as of one version of the thread or
+-- post; the state before the first edit is saved along with it, so content
+-- that was never edited has no rows.
+CREATE TABLE IF NOT EXISTS thread_revisions (
+    thread BIGINT REFERENCES threads(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    title VARCHAR NOT NULL,
+    message TEXT NOT NULL,
+    editor citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (thread, version)
+);
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+    post BIGINT REFERENCES posts(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    message TEXT NOT NULL,
+    editor citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (post, version)
+);
+
+-- Polls. A thread has at most one, created along with it. poll_voters holds
+-- one row per ballot, so a user votes once and changing the ballot locks that
+-- row; poll_choices are the options the ballot picked.
+CREATE TABLE IF NOT EXISTS polls (
+    id BIGSERIAL PRIMARY KEY,
+    thread BIGINT UNIQUE NOT NULL REFERENCES threads(id) ON DELETE CASCADE,
+    question TEXT NOT NULL,
+    multiple BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id BIGSERIAL PRIMARY KEY,
+    poll BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    text TEXT NOT NULL,
+    UNIQUE (poll, position)
+);
+
+CREATE TABLE IF NOT EXISTS poll_voters (
+    poll BIGINT REFERENCES polls(id) ON DELETE CASCADE,
+    nickname citext REFERENCES users(nickname) ON UPDATE CASCADE ON DELETE CASCADE,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (poll, nickname)
+);
+
+CREATE TABLE IF NOT EXISTS poll_choices (
+    poll BIGINT NOT NULL,
+    nickname citext NOT NULL,
+    option BIGINT NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+    PRIMARY KEY (poll, nickname, option),
+    FOREIGN KEY (poll, nickname) REFERENCES poll_voters(poll, nickname) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS poll_choices_option_idx ON poll_choices (option);
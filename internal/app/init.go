@@ -4,8 +4,12 @@ import (
 	forumRepository "technopark_db_forum/internal/forum/repository"
 	"technopark_db_forum/internal/forum/usecase"
 	forumUsecase "technopark_db_forum/internal/forum/usecase"
+	idempotencyHandler "technopark_db_forum/internal/idempotency/delivery"
+	idempotencyRepository "technopark_db_forum/internal/idempotency/repository"
+	idempotencyUsecase "technopark_db_forum/internal/idempotency/usecase"
 	postRepository "technopark_db_forum/internal/posts/repository"
 	postsUsecase "technopark_db_forum/internal/posts/usecase"
+	"technopark_db_forum/internal/ranking"
 	serviceHandler "technopark_db_forum/internal/service/delivery"
 	serviceRepository "technopark_db_forum/internal/service/repository"
 	serviceUsecase "technopark_db_forum/internal/service/usecase"
@@ -14,6 +18,7 @@ import (
 	userRepository "technopark_db_forum/internal/users/repository"
 	userUsecase "technopark_db_forum/internal/users/usecase"
 	"technopark_db_forum/pkg/logger"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"technopark_db_forum/internal/forum/delivery"
@@ -22,20 +27,42 @@ import (
 	usersHandler "technopark_db_forum/internal/users/delivery"
 )
 
+const (
+	// defaultIdempotencyTTL is how long a stored create response is replayed.
+	defaultIdempotencyTTL = 24 * time.Hour
+	// defaultRankingInterval is how often trending scores are recomputed.
+	defaultRankingInterval = 30 * time.Second
+	// defaultIdempotencyPurgeInterval is how often expired keys are deleted.
+	defaultIdempotencyPurgeInterval = time.Hour
+)
+
 type Server struct {
 	Echo *echo.Echo
 
-	forumUsecase   usecase.ForumUsecase
-	usersUsecase   userUsecase.UsersUsecase
-	postsUsecase   postsUsecase.PostUsecase
-	threadUsecase  threadUsecase.ThreadUsecase
-	serviceUsecase serviceUsecase.ServiceUsecase
+	// IdempotencyTTL is how long a response is replayed for retries that
+	// reuse its Idempotency-Key.
+	IdempotencyTTL time.Duration
+	// RankingInterval is how often the trending scores of threads with new
+	// votes or posts are recomputed.
+	RankingInterval time.Duration
+	// IdempotencyPurgeInterval is how often keys older than IdempotencyTTL
+	// are deleted.
+	IdempotencyPurgeInterval time.Duration
+
+	forumUsecase       usecase.ForumUsecase
+	usersUsecase       userUsecase.UsersUsecase
+	postsUsecase       postsUsecase.PostUsecase
+	threadUsecase      threadUsecase.ThreadUsecase
+	serviceUsecase     serviceUsecase.ServiceUsecase
+	idempotencyUsecase idempotencyUsecase.IdempotencyUsecase
 
 	forumHandler   delivery.ForumHandler
 	usersHandler   usersHandler.UserHandler
 	postsHandler   postsHandler.PostHandler
 	threadHandler  threadHandler.ThreadHandler
 	serviceHandler serviceHandler.ServiceHandler
+
+	idempotency echo.MiddlewareFunc
 }
 
 func (s *Server) init(URL string) {
@@ -47,6 +74,12 @@ func (s *Server) init(URL string) {
 
 func (s *Server) Start(host, URL string) error {
 	s.init(URL)
+	if s.RankingInterval > 0 {
+		go s.threadUsecase.RunRankings(s.RankingInterval, nil)
+	}
+	if s.IdempotencyPurgeInterval > 0 {
+		go s.idempotencyUsecase.RunPurge(s.IdempotencyPurgeInterval, nil)
+	}
 	return s.Echo.Start("localhost" + host)
 }
 
@@ -75,12 +108,17 @@ func (s *Server) makeUseCase(URL string) {
 	if err != nil {
 		s.Echo.Logger.Error(err)
 	}
+	idempotencyRepo, err := idempotencyRepository.NewPostgres(URL)
+	if err != nil {
+		s.Echo.Logger.Error(err)
+	}
 
 	s.usersUsecase = userUsecase.NewUserUsecase(usersRepo)
-	s.threadUsecase = threadUsecase.NewThreadUsecase(threadRepo, usersRepo, forumRepo)
+	s.threadUsecase = threadUsecase.NewThreadUsecase(threadRepo, usersRepo, forumRepo, ranking.Default())
 	s.postsUsecase = postsUsecase.NewPostUsecase(postRepo, usersRepo, threadRepo, forumRepo)
 	s.forumUsecase = forumUsecase.NewUserUsecase(forumRepo, usersRepo)
-	s.serviceUsecase = serviceUsecase.NewServiceUsecase(service)
+	s.serviceUsecase = serviceUsecase.NewServiceUsecase(service, forumRepo)
+	s.idempotencyUsecase = idempotencyUsecase.NewIdempotencyUsecase(idempotencyRepo, s.IdempotencyTTL)
 }
 
 func (s *Server) makeHandlers() {
@@ -89,6 +127,7 @@ func (s *Server) makeHandlers() {
 	s.usersHandler = usersHandler.NewUserHandler(s.usersUsecase)
 	s.postsHandler = postsHandler.NewPostHandler(s.postsUsecase)
 	s.threadHandler = threadHandler.NewThreadHandler(s.threadUsecase)
+	s.idempotency = idempotencyHandler.Middleware(s.idempotencyUsecase)
 }
 
 func (s *Server) makeEchoLogger() {
@@ -100,32 +139,86 @@ func (s *Server) makeRouter() {
 	v1.Use(logger.Middleware())
 
 	v1.GET("/service/status", s.serviceHandler.GetStatus)
+	v1.GET("/service/stats", s.serviceHandler.GetStats)
 	v1.POST("/service/clear", s.serviceHandler.Clear)
 
-	v1.POST("/forum/create", s.forumHandler.CreateForum)
+	v1.GET("/forums", s.forumHandler.GetForums)
+	v1.POST("/forum/create", s.forumHandler.CreateForum, s.idempotency)
 	v1.GET("/forum/:slug/details", s.forumHandler.GetForum)
+	v1.POST("/forum/:slug/details", s.forumHandler.UpdateForum)
+	v1.DELETE("/forum/:slug", s.forumHandler.DeleteForum)
 	v1.GET("/forum/:slug/users", s.forumHandler.GetForumUsers)
+	v1.GET("/forum/:slug/children", s.forumHandler.GetForumChildren)
+	v1.POST("/forum/:slug/move", s.forumHandler.MoveForum)
+	v1.GET("/forum/:slug/stats", s.forumHandler.GetActivity)
+	v1.POST("/forum/:slug/settings", s.forumHandler.UpdateSettings)
+	v1.GET("/forum/:slug/members", s.forumHandler.GetMembers)
+	v1.POST("/forum/:slug/members", s.forumHandler.AddMember)
+	v1.POST("/forum/:slug/members/join", s.forumHandler.JoinForum)
+	v1.DELETE("/forum/:slug/members/:nickname", s.forumHandler.RemoveMember)
+	v1.GET("/forum/:slug/moderators", s.forumHandler.GetModerators)
+	v1.POST("/forum/:slug/moderators", s.forumHandler.AddModerator)
+	v1.DELETE("/forum/:slug/moderators/:nickname", s.forumHandler.RemoveModerator)
+	v1.POST("/forum/:slug/bans", s.forumHandler.BanUser)
+	v1.DELETE("/forum/:slug/bans/:nickname", s.forumHandler.UnbanUser)
+	v1.POST("/forum/:slug/clear", s.forumHandler.ClearForum)
 
 	v1.GET("/forum/:slug/threads", s.threadHandler.GetThreadMsgs)
+	v1.POST("/forum/:slug/tags/rename", s.threadHandler.RenameTag)
+	v1.POST("/forum/:slug/tags/merge", s.threadHandler.MergeTags)
+	v1.GET("/tags", s.threadHandler.GetTags)
+	v1.GET("/threads/trending", s.threadHandler.GetTrending)
 
-	v1.POST("/user/:nickname/create", s.usersHandler.CreateUser)
+	v1.POST("/user/:nickname/create", s.usersHandler.CreateUser, s.idempotency)
+	v1.POST("/user/import", s.usersHandler.ImportUsers)
 	v1.GET("/user/:nickname/profile", s.usersHandler.GetUser)
 	v1.POST("/user/:nickname/profile", s.usersHandler.UpdateUser)
-
-	v1.POST("/thread/:slug_or_id/create", s.postsHandler.CreatePosts)
+	v1.POST("/user/:nickname/rename", s.usersHandler.RenameUser)
+	v1.GET("/user/:nickname/export", s.usersHandler.ExportUser)
+	v1.POST("/user/:nickname/follow", s.usersHandler.Follow)
+	v1.POST("/user/:nickname/unfollow", s.usersHandler.Unfollow)
+	v1.GET("/user/:nickname/following", s.usersHandler.GetFollowing)
+	v1.GET("/user/:nickname/feed", s.usersHandler.GetFeed)
+	v1.POST("/user/:nickname/mute", s.usersHandler.Mute)
+	v1.POST("/user/:nickname/unmute", s.usersHandler.Unmute)
+	v1.POST("/user/:nickname/block", s.usersHandler.Block)
+	v1.POST("/user/:nickname/unblock", s.usersHandler.Unblock)
+	v1.GET("/user/:nickname/relations", s.usersHandler.GetRelations)
+	v1.POST("/user/:nickname/role", s.usersHandler.SetRole)
+
+	v1.POST("/thread/:slug_or_id/create", s.postsHandler.CreatePosts, s.idempotency)
 	v1.GET("/thread/:slug_or_id/details", s.threadHandler.GetThread)
 	v1.POST("/thread/:slug_or_id/details", s.threadHandler.UpdateThread)
 	v1.GET("/thread/:slug_or_id/posts", s.postsHandler.GetThreadPosts)
 	v1.POST("/thread/:slug_or_id/vote", s.threadHandler.CreateVote)
+	v1.DELETE("/thread/:slug_or_id/vote", s.threadHandler.RetractVote)
+	v1.GET("/thread/:slug_or_id/vote", s.threadHandler.GetVote)
+	v1.GET("/thread/:slug_or_id/votes", s.threadHandler.GetVotes)
+	v1.POST("/thread/:slug_or_id/state", s.threadHandler.SetThreadState)
+	v1.POST("/thread/:slug_or_id/move", s.threadHandler.MoveThread)
+	v1.POST("/thread/:slug_or_id/merge", s.threadHandler.MergeThread)
+	v1.DELETE("/thread/:slug_or_id", s.threadHandler.DeleteThread)
+	v1.GET("/thread/:slug_or_id/revisions", s.threadHandler.GetRevisions)
+	v1.GET("/thread/:slug_or_id/revisions/diff", s.threadHandler.DiffRevisions)
+	v1.POST("/thread/:slug_or_id/revisions/:version/rollback", s.threadHandler.RollbackRevision)
+	v1.GET("/thread/:slug_or_id/poll", s.threadHandler.GetPoll)
+	v1.POST("/thread/:slug_or_id/poll", s.threadHandler.CastBallot)
 
 	v1.GET("/post/:id/details", s.postsHandler.GetPost)
 	v1.POST("/post/:id/details", s.postsHandler.UpdatePost)
+	v1.POST("/post/:id/split", s.postsHandler.SplitPost)
+	v1.GET("/post/:id/revisions", s.postsHandler.GetRevisions)
+	v1.GET("/post/:id/revisions/diff", s.postsHandler.DiffRevisions)
+	v1.POST("/post/:id/revisions/:version/rollback", s.postsHandler.RollbackRevision)
 
-	v1.POST("/forum/:slug/create", s.threadHandler.CreateThread)
+	v1.POST("/forum/:slug/create", s.threadHandler.CreateThread, s.idempotency)
 }
 
 func New(echo *echo.Echo) *Server {
 	return &Server{
-		Echo: echo,
+		Echo:                     echo,
+		IdempotencyTTL:           defaultIdempotencyTTL,
+		RankingInterval:          defaultRankingInterval,
+		IdempotencyPurgeInterval: defaultIdempotencyPurgeInterval,
 	}
 }
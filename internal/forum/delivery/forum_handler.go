@@ -8,6 +8,7 @@ import (
 	"technopark_db_forum/internal/forum/usecase"
 	"technopark_db_forum/internal/models"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/timeseries"
 
 	"github.com/labstack/echo/v4"
 )
@@ -35,6 +36,10 @@ func (h ForumHandler) CreateForum(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", forum.UserNickname))
 		case e.ErrDuplicate:
 			return c.JSON(http.StatusConflict, createdForum)
+		case e.ErrParentNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find parent forum with slug: %s", forum.Parent))
+		case e.ErrInvalidSlug:
+			return echo.NewHTTPError(http.StatusBadRequest, slugRules)
 		case e.ErrThreadNotFound:
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread author by nickname: %s", forum.UserNickname))
 		default:
@@ -47,45 +52,368 @@ func (h ForumHandler) CreateForum(c echo.Context) error {
 func (h ForumHandler) GetForum(c echo.Context) error {
 	slug := c.Param("slug")
 
-	forum, err := h.ForumUsecase.GetForumBySlug(slug)
+	forum, err := h.ForumUsecase.GetForumBySlug(slug, c.QueryParam("viewer"))
 	if err != nil {
-		if err == sql.ErrNoRows {
+		switch err {
+		case sql.ErrNoRows:
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum with slug: %s", slug))
+		case e.ErrMembersOnly:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", slug))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
 		}
-		return c.JSON(http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, forum)
 }
 
+const slugRules = "slug must be 1 to 128 ASCII letters, digits, '-' and '_', and not all digits"
+
+// HeaderNextCursor carries the cursor of the next page of forum members.
+const HeaderNextCursor = "X-Next-Cursor"
+
 func (h ForumHandler) GetForumUsers(c echo.Context) error {
 	slug := c.Param("slug")
 
+	// A missing limit means the default page; an explicit 0 means no limit.
 	limit, err := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
 	if err != nil {
 		limit = 100
 	}
-	// if limit == 10 {
-	// 	fmt.Sprint(limit)
-	// }
 	since := c.QueryParam("since")
 	desc, err := strconv.ParseBool(c.QueryParam("desc"))
 	if err != nil {
 		desc = false
 	}
 
-	users, err := h.ForumUsecase.GetForumUsersBySlug(slug, models.ThreadOptions{
-		Limit: limit,
-		Since: since,
-		Desc:  desc,
+	sortBy := c.QueryParam("sort")
+	switch sortBy {
+	case "nickname", "posts", "activity", "karma":
+	default:
+		sortBy = "nickname"
+	}
+
+	role := c.QueryParam("role")
+	switch role {
+	case "", models.ForumRoleOwner, models.ForumRoleModerator, models.ForumRoleMember, models.ForumRoleParticipant:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "role must be owner, moderator, member or participant")
+	}
+
+	users, next, err := h.ForumUsecase.GetForumUsersBySlug(slug, models.ForumUsersOptions{
+		Limit:  limit,
+		Since:  since,
+		Desc:   desc,
+		SortBy: sortBy,
+		Role:   role,
+		Viewer: c.QueryParam("viewer"),
+	}, c.QueryParam("cursor"))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return c.JSON(http.StatusNotFound, err)
+		case e.ErrMembersOnly:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", slug))
+		case e.ErrInvalidCursor:
+			return echo.NewHTTPError(http.StatusBadRequest, "cursor is malformed or belongs to another sort order")
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	if next != "" {
+		c.Response().Header().Set(HeaderNextCursor, next)
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// moderationError maps the errors shared by the moderation endpoints.
+func moderationError(c echo.Context, err error, slug, nickname string) error {
+	switch err {
+	case sql.ErrNoRows:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum with slug: %s", slug))
+	case e.ErrUserNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+	case e.ErrActorRequired:
+		return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+	case e.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to moderate forum %s", c.QueryParam("actor"), slug))
+	case e.ErrMembersOnly:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", slug))
+	case e.ErrBanned:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is banned from forum %s", c.QueryParam("actor"), slug))
+	case e.ErrInvalidSettings:
+		return echo.NewHTTPError(http.StatusBadRequest, "visibility must be public or members, posting open, read_only or moderated, thread_creation anyone, members or moderators")
+	default:
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+}
+
+func (h ForumHandler) GetModerators(c echo.Context) error {
+	slug := c.Param("slug")
+
+	moderators, err := h.ForumUsecase.GetModerators(slug)
+	if err != nil {
+		return moderationError(c, err, slug, "")
+	}
+	return c.JSON(http.StatusOK, moderators)
+}
+
+func (h ForumHandler) AddModerator(c echo.Context) error {
+	slug := c.Param("slug")
+
+	var req models.ForumMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	moderators, err := h.ForumUsecase.AddModerator(slug, req.Nickname, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, req.Nickname)
+	}
+	return c.JSON(http.StatusOK, moderators)
+}
+
+func (h ForumHandler) RemoveModerator(c echo.Context) error {
+	slug := c.Param("slug")
+	nickname := c.Param("nickname")
+
+	moderators, err := h.ForumUsecase.RemoveModerator(slug, nickname, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, nickname)
+	}
+	return c.JSON(http.StatusOK, moderators)
+}
+
+func (h ForumHandler) BanUser(c echo.Context) error {
+	slug := c.Param("slug")
+
+	var req models.ForumMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	if err := h.ForumUsecase.BanUser(slug, req.Nickname, c.QueryParam("actor")); err != nil {
+		return moderationError(c, err, slug, req.Nickname)
+	}
+	return c.JSON(http.StatusOK, req)
+}
+
+func (h ForumHandler) UnbanUser(c echo.Context) error {
+	slug := c.Param("slug")
+	nickname := c.Param("nickname")
+
+	if err := h.ForumUsecase.UnbanUser(slug, nickname, c.QueryParam("actor")); err != nil {
+		return moderationError(c, err, slug, nickname)
+	}
+	return c.JSON(http.StatusOK, models.ForumMemberRequest{Nickname: nickname})
+}
+
+func (h ForumHandler) ClearForum(c echo.Context) error {
+	slug := c.Param("slug")
+
+	forum, err := h.ForumUsecase.ClearForum(slug, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, "")
+	}
+	return c.JSON(http.StatusOK, forum)
+}
+
+func (h ForumHandler) UpdateForum(c echo.Context) error {
+	slug := c.Param("slug")
+
+	var update models.ForumUpdate
+	if err := c.Bind(&update); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	forum, err := h.ForumUsecase.UpdateForum(slug, update, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, update.UserNickname)
+	}
+	return c.JSON(http.StatusOK, forum)
+}
+
+func (h ForumHandler) DeleteForum(c echo.Context) error {
+	slug := c.Param("slug")
+
+	archive, _ := strconv.ParseBool(c.QueryParam("archive"))
+	forum, err := h.ForumUsecase.DeleteForum(slug, c.QueryParam("actor"), archive)
+	if err != nil {
+		return moderationError(c, err, slug, "")
+	}
+	if archive {
+		return c.JSON(http.StatusOK, forum)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h ForumHandler) GetForums(c echo.Context) error {
+	limit, err := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
+	if err != nil {
+		limit = 100
+	}
+	desc, err := strconv.ParseBool(c.QueryParam("desc"))
+	if err != nil {
+		desc = false
+	}
+	archived, _ := strconv.ParseBool(c.QueryParam("archived"))
+
+	sortBy := c.QueryParam("sort")
+	switch sortBy {
+	case "slug", "title", "posts", "threads", "activity":
+	default:
+		sortBy = "slug"
+	}
+
+	forums, err := h.ForumUsecase.GetForums(models.ForumListOptions{
+		Limit:    limit,
+		Since:    c.QueryParam("since"),
+		Desc:     desc,
+		SortBy:   sortBy,
+		Query:    c.QueryParam("q"),
+		Archived: archived,
+		Viewer:   c.QueryParam("viewer"),
 	})
-	// if len(users) == 0 {
-	// 	return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum by slug: %s", slug))
-	// }
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, forums)
+}
+
+func (h ForumHandler) GetForumChildren(c echo.Context) error {
+	slug := c.Param("slug")
+
+	children, err := h.ForumUsecase.GetForumChildren(slug, c.QueryParam("viewer"))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return c.JSON(http.StatusNotFound, err)
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum with slug: %s", slug))
+		}
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", slug))
 		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
-	return c.JSON(http.StatusOK, users)
+	return c.JSON(http.StatusOK, children)
+}
+
+func (h ForumHandler) MoveForum(c echo.Context) error {
+	slug := c.Param("slug")
+
+	var move models.ForumMove
+	if err := c.Bind(&move); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	forum, err := h.ForumUsecase.MoveForum(slug, move.Parent, c.QueryParam("actor"))
+	if err != nil {
+		switch err {
+		case e.ErrParentNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find parent forum with slug: %s", move.Parent))
+		case e.ErrForumCycle:
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("Forum %s can't be moved under its own subforum %s", slug, move.Parent))
+		default:
+			return moderationError(c, err, slug, "")
+		}
+	}
+	return c.JSON(http.StatusOK, forum)
+}
+
+func (h ForumHandler) UpdateSettings(c echo.Context) error {
+	slug := c.Param("slug")
+
+	var settings models.ForumSettings
+	if err := c.Bind(&settings); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	forum, err := h.ForumUsecase.UpdateSettings(slug, settings, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, "")
+	}
+	return c.JSON(http.StatusOK, forum)
+}
+
+func (h ForumHandler) JoinForum(c echo.Context) error {
+	slug := c.Param("slug")
+
+	membership, err := h.ForumUsecase.Join(slug, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, "")
+	}
+	return c.JSON(http.StatusOK, membership)
+}
+
+func (h ForumHandler) AddMember(c echo.Context) error {
+	slug := c.Param("slug")
+
+	var req models.ForumMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	membership, err := h.ForumUsecase.AddMember(slug, req.Nickname, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, req.Nickname)
+	}
+	return c.JSON(http.StatusOK, membership)
+}
+
+func (h ForumHandler) RemoveMember(c echo.Context) error {
+	slug := c.Param("slug")
+	nickname := c.Param("nickname")
+
+	if err := h.ForumUsecase.RemoveMember(slug, nickname, c.QueryParam("actor")); err != nil {
+		return moderationError(c, err, slug, nickname)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h ForumHandler) GetMembers(c echo.Context) error {
+	slug := c.Param("slug")
+
+	state := c.QueryParam("state")
+	switch state {
+	case models.MemberPending, models.MemberInvited, models.MemberActive:
+	case "":
+		state = models.MemberActive
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "state must be active, pending or invited")
+	}
+
+	members, err := h.ForumUsecase.GetMembers(slug, state, c.QueryParam("actor"))
+	if err != nil {
+		return moderationError(c, err, slug, "")
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+func (h ForumHandler) GetActivity(c echo.Context) error {
+	slug := c.Param("slug")
+
+	from, to, err := timeseries.Parse(c.QueryParam("from"), c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		bucket = timeseries.Day
+	}
+
+	buckets, err := h.ForumUsecase.GetActivity(slug, c.QueryParam("viewer"), models.ActivityOptions{
+		From:   from,
+		To:     to,
+		Bucket: bucket,
+	})
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum with slug: %s", slug))
+		case e.ErrMembersOnly:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", slug))
+		case timeseries.ErrInvalidRange:
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, buckets)
 }
@@ -1,7 +1,12 @@
 package forumRepository
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/pkg/audit"
 	"technopark_db_forum/pkg/errors"
 
 	"github.com/lib/pq"
@@ -14,7 +19,34 @@ type ForumRepository interface {
 	CreateForum(forum models.Forum) (models.Forum, error)
 	CreateForumUser(forum, user string) (models.ForumUser, error)
 	GetForumBySlug(slug string) (models.Forum, error)
-	GetForumUsers(slug string, options models.ThreadOptions) ([]models.User, error)
+	GetForumUsers(slug string, options models.ForumUsersOptions) ([]models.ForumMember, error)
+
+	IsForumModerator(forum, nickname string) (bool, error)
+	AddForumModerator(forum, nickname, actor string) error
+	RemoveForumModerator(forum, nickname, actor string) error
+	GetForumModerators(forum string) ([]string, error)
+
+	BanUser(forum, nickname, actor string) error
+	UnbanUser(forum, nickname, actor string) error
+	IsBanned(forum, nickname string) (bool, error)
+	ClearForum(forum, actor string) error
+
+	UpdateForum(forum models.Forum, actor string) (models.Forum, error)
+	DeleteForum(forum models.Forum, actor string) error
+
+	GetForums(options models.ForumListOptions) ([]models.ForumSummary, error)
+
+	GetForumChildren(slug, viewer string) ([]models.Forum, error)
+	GetForumBreadcrumbs(slug string) ([]models.ForumCrumb, error)
+	MoveForum(slug, parent, actor string) (models.Forum, error)
+
+	UpdateForumSettings(slug string, settings models.ForumSettings, actor string) (models.Forum, error)
+	GetMemberState(forum, nickname string) (string, error)
+	SetMemberState(forum, nickname, state string) (models.ForumMembership, error)
+	RemoveMember(forum, nickname string) error
+	GetMembers(forum, state string) ([]models.ForumMembership, error)
+
+	GetActivity(forum string, options models.ActivityOptions) ([]models.ActivityBucket, error)
 }
 
 type Postgres struct {
@@ -33,13 +65,20 @@ func NewPostgres(url string) (*Postgres, error) {
 	return &Postgres{DB: db}, nil
 }
 
+// forumColumns is the column list every query returning a models.Forum selects.
+const forumColumns = `slug, title, user_nick, posts, threads, archived, COALESCE(parent, '') AS parent, category,
+	total_posts, total_threads, visibility, posting, thread_creation`
+
 func (p *Postgres) CreateForum(forum models.Forum) (models.Forum, error) {
 	var res models.Forum
-	query := `INSERT INTO forums (slug, title, user_nick) VALUES ($1, $2, $3) RETURNING slug, title, user_nick, posts, threads`
-	err := p.DB.QueryRow(query, forum.Slug, forum.Title, forum.UserNickname).Scan(&res.Slug, &res.Title, &res.UserNickname, &res.PostsCount, &res.ThreadsCount)
+	query := `INSERT INTO forums (slug, title, user_nick, parent, category) VALUES ($1, $2, $3, NULLIF($4, '')::citext, $5) RETURNING ` + forumColumns
+	err := p.DB.QueryRowx(query, forum.Slug, forum.Title, forum.UserNickname, forum.Parent, forum.Category).StructScan(&res)
 	if err != nil {
 		pgErr, ok := err.(*pq.Error)
 		if ok && pgErr.Code == "23503" {
+			if pgErr.Constraint == "forums_parent_fkey" {
+				return models.Forum{}, errors.ErrParentNotFound
+			}
 			return models.Forum{}, errors.ErrUserNotFound
 		}
 
@@ -65,7 +104,7 @@ func (p *Postgres) CreateForumUser(forum, user string) (models.ForumUser, error)
 }
 
 func (p *Postgres) GetForumBySlug(slug string) (models.Forum, error) {
-	query := `SELECT slug, title, user_nick, posts, threads FROM forums WHERE slug = $1`
+	query := `SELECT ` + forumColumns + ` FROM forums WHERE slug = $1`
 	forum := models.Forum{}
 	err := p.DB.Get(&forum, query, slug)
 	return forum, err
@@ -80,38 +119,489 @@ func (p *Postgres) GetUsers(slug string) ([]models.User, error) {
 	return users, err
 }
 
-func (p *Postgres) GetForumUsers(slug string, options models.ThreadOptions) ([]models.User, error) {
-	query := `SELECT users.nickname, users.fullname, users.email, users.about FROM users JOIN forum_users ON forum_users.user_nick = users.nickname WHERE forum_users.forum = $1`
-	if options.Since != "" && options.Desc {
-		query += ` AND users.nickname < $2`
-	} else if options.Since != "" && !options.Desc {
-		query += ` AND users.nickname > $2`
+// forumMemberKeys maps the member sort keys to the column ordered on before
+// the nickname.
+var forumMemberKeys = map[string]string{
+	"posts":    "m.posts",
+	"activity": "m.last_seen",
+	"karma":    "m.karma",
+}
+
+func (p *Postgres) GetForumUsers(slug string, options models.ForumUsersOptions) ([]models.ForumMember, error) {
+	members := `SELECT users.nickname, users.fullname, users.email, users.about,
+			forum_users.first_seen, forum_users.last_seen, forum_users.threads, forum_users.posts,
+			COALESCE(user_stats.karma, 0) AS karma,
+			CASE
+				WHEN forums.user_nick = users.nickname THEN 'owner'
+				WHEN EXISTS (SELECT 1 FROM forum_moderators WHERE forum = forums.slug AND nickname = users.nickname) THEN 'moderator'
+				WHEN EXISTS (SELECT 1 FROM forum_members WHERE forum = forums.slug AND nickname = users.nickname AND state = 'active') THEN 'member'
+				ELSE 'participant'
+			END AS role
+		FROM forum_users
+		JOIN users ON users.nickname = forum_users.user_nick
+		JOIN forums ON forums.slug = forum_users.forum
+		LEFT JOIN user_stats ON user_stats.nickname = users.nickname
+		WHERE forum_users.forum = $1`
+	query := `SELECT m.* FROM (` + members + `) m WHERE TRUE`
+	args := []interface{}{slug}
+
+	if options.Role != "" {
+		args = append(args, options.Role)
+		query += fmt.Sprintf(` AND m.role = $%d`, len(args))
 	}
-	query += ` ORDER BY users.nickname`
+
+	cmp := ">"
+	order := ""
 	if options.Desc {
-		query += ` DESC`
+		cmp = "<"
+		order = " DESC"
+	}
+	key, keyed := forumMemberKeys[options.SortBy]
+	switch {
+	case options.Cursor != nil && keyed:
+		var value interface{} = options.Cursor.Count
+		if options.SortBy == "activity" {
+			value = options.Cursor.Seen
+		}
+		args = append(args, value, options.Cursor.Nickname)
+		query += fmt.Sprintf(` AND (%s, m.nickname) %s ($%d, $%d::citext)`, key, cmp, len(args)-1, len(args))
+	case options.Cursor != nil:
+		args = append(args, options.Cursor.Nickname)
+		query += fmt.Sprintf(` AND m.nickname %s $%d`, cmp, len(args))
+	case options.Since != "" && keyed:
+		// The legacy cursor is only a nickname; the key is looked up.
+		args = append(args, options.Since)
+		query += fmt.Sprintf(` AND (%s, m.nickname) %s ((SELECT %s FROM (%s) s WHERE s.nickname = $%d), $%d::citext)`,
+			key, cmp, strings.Replace(key, "m.", "s.", 1), members, len(args), len(args))
+	case options.Since != "":
+		args = append(args, options.Since)
+		query += fmt.Sprintf(` AND m.nickname %s $%d`, cmp, len(args))
+	}
+
+	if keyed {
+		query += fmt.Sprintf(` ORDER BY %s%s, m.nickname%s`, key, order, order)
+	} else {
+		query += ` ORDER BY m.nickname` + order
 	}
 	if options.Limit != 0 {
-		if options.Since != "" {
-			query += ` LIMIT $3`
+		args = append(args, options.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	res := make([]models.ForumMember, 0)
+	if err := p.DB.Select(&res, query, args...); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *Postgres) IsForumModerator(forum, nickname string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM forum_moderators WHERE forum = $1 AND nickname = $2)`
+	var moderator bool
+	err := p.DB.Get(&moderator, query, forum, nickname)
+	return moderator, err
+}
+
+func (p *Postgres) AddForumModerator(forum, nickname, actor string) error {
+	query := `INSERT INTO forum_moderators (forum, nickname) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	return p.changeForumUser(query, "forum.moderator.add", forum, nickname, actor)
+}
+
+func (p *Postgres) RemoveForumModerator(forum, nickname, actor string) error {
+	query := `DELETE FROM forum_moderators WHERE forum = $1 AND nickname = $2`
+	return p.changeForumUser(query, "forum.moderator.remove", forum, nickname, actor)
+}
+
+func (p *Postgres) GetForumModerators(forum string) ([]string, error) {
+	query := `SELECT nickname FROM forum_moderators WHERE forum = $1 ORDER BY nickname`
+	moderators := []string{}
+	err := p.DB.Select(&moderators, query, forum)
+	return moderators, err
+}
+
+func (p *Postgres) BanUser(forum, nickname, actor string) error {
+	query := `INSERT INTO forum_bans (forum, nickname) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	return p.changeForumUser(query, "forum.ban", forum, nickname, actor)
+}
+
+func (p *Postgres) UnbanUser(forum, nickname, actor string) error {
+	query := `DELETE FROM forum_bans WHERE forum = $1 AND nickname = $2`
+	return p.changeForumUser(query, "forum.unban", forum, nickname, actor)
+}
+
+// changeForumUser runs query, which adds nickname to or removes it from one
+// of the per-forum lists, and records action when it changed anything.
+func (p *Postgres) changeForumUser(query, action, forum, nickname, actor string) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(query, forum, nickname)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return errors.ErrUserNotFound
+		}
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		details := struct {
+			Nickname string `json:"nickname"`
+		}{nickname}
+		if err = audit.Record(tx, actor, action, forum, details); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) IsBanned(forum, nickname string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM forum_bans WHERE forum = $1 AND nickname = $2)`
+	var banned bool
+	err := p.DB.Get(&banned, query, forum, nickname)
+	return banned, err
+}
+
+// ClearForum removes every thread of a forum together with their posts and
+// votes; the delete triggers take them off the counters of the forum and its
+// ancestors.
+func (p *Postgres) ClearForum(forum, actor string) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM threads WHERE forum = $1`, forum)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM forum_users WHERE forum = $1`, forum); err != nil {
+		return err
+	}
+
+	details := struct {
+		Threads int64 `json:"threads"`
+	}{}
+	details.Threads, _ = res.RowsAffected()
+	if err = audit.Record(tx, actor, "forum.clear", forum, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) UpdateForum(forum models.Forum, actor string) (models.Forum, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Forum{}, err
+	}
+	defer tx.Rollback()
+
+	var res models.Forum
+	query := `UPDATE forums SET title = $2, user_nick = $3, archived = $4, category = $5 WHERE slug = $1
+		RETURNING ` + forumColumns
+	err = tx.QueryRowx(query, forum.Slug, forum.Title, forum.UserNickname, forum.Archived, forum.Category).StructScan(&res)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return models.Forum{}, errors.ErrUserNotFound
+		}
+		return models.Forum{}, err
+	}
+
+	if err = audit.Record(tx, actor, "forum.update", res.Slug, res); err != nil {
+		return models.Forum{}, err
+	}
+	return res, tx.Commit()
+}
+
+// DeleteForum removes the forum. Threads, posts, votes, participation rows and
+// moderation settings go with it through ON DELETE CASCADE, and the counter
+// triggers keep user statistics in step.
+func (p *Postgres) DeleteForum(forum models.Forum, actor string) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = audit.Record(tx, actor, "forum.delete", forum.Slug, forum); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM forums WHERE slug = $1`, forum.Slug); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// forumSortColumns maps the listing sort keys to forum columns; every order
+// is made total by the slug.
+var forumSortColumns = map[string]string{
+	"slug":     "slug",
+	"title":    "title",
+	"posts":    "posts",
+	"threads":  "threads",
+	"activity": "last_activity",
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+type forumSummaryRow struct {
+	models.Forum
+	LastActivity      sql.NullTime   `db:"last_activity"`
+	LastThreadTitle   sql.NullString `db:"last_thread_title"`
+	LastThreadCreated sql.NullTime   `db:"last_thread_created"`
+}
+
+func (p *Postgres) GetForums(options models.ForumListOptions) ([]models.ForumSummary, error) {
+	column, ok := forumSortColumns[options.SortBy]
+	if !ok {
+		column = "slug"
+	}
+
+	query := `SELECT f.slug, f.title, f.user_nick, f.posts, f.threads, f.archived, COALESCE(f.parent, '') AS parent,
+		f.category, f.total_posts, f.total_threads, f.visibility, f.posting, f.thread_creation, f.last_activity,
+		lt.title AS last_thread_title, lt.created AS last_thread_created
+		FROM forums f
+		LEFT JOIN LATERAL (
+			SELECT title, created FROM threads WHERE threads.forum = f.slug ORDER BY created DESC, id DESC LIMIT 1
+		) lt ON TRUE
+		WHERE ` + policy.ReadableBy("f", "$1")
+	args := []interface{}{options.Viewer}
+
+	if !options.Archived {
+		query += ` AND NOT f.archived`
+	}
+	if options.Query != "" {
+		args = append(args, "%"+likeEscaper.Replace(options.Query)+"%")
+		query += fmt.Sprintf(` AND (f.title ILIKE $%d OR f.slug::text ILIKE $%d)`, len(args), len(args))
+	}
+
+	cmp := ">"
+	order := ""
+	if options.Desc {
+		cmp = "<"
+		order = " DESC"
+	}
+	if options.Since != "" {
+		args = append(args, options.Since)
+		if column == "slug" {
+			query += fmt.Sprintf(` AND f.slug %s $%d`, cmp, len(args))
 		} else {
-			query += ` LIMIT $2`
+			query += fmt.Sprintf(` AND (f.%s, f.slug) %s ((SELECT %s FROM forums WHERE slug = $%d), $%d::citext)`,
+				column, cmp, column, len(args), len(args))
+		}
+	}
+
+	if column == "slug" {
+		query += ` ORDER BY f.slug` + order
+	} else {
+		query += fmt.Sprintf(` ORDER BY f.%s%s, f.slug%s`, column, order, order)
+	}
+	if options.Limit != 0 {
+		args = append(args, options.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows := make([]forumSummaryRow, 0)
+	if err := p.DB.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	forums := make([]models.ForumSummary, 0, len(rows))
+	for _, row := range rows {
+		forum := models.ForumSummary{Forum: row.Forum, LastActivity: row.LastActivity.Time}
+		if row.LastThreadTitle.Valid {
+			forum.LastThread = &models.ForumLastThread{
+				Title:   row.LastThreadTitle.String,
+				Created: row.LastThreadCreated.Time,
+			}
 		}
+		forums = append(forums, forum)
 	}
+	return forums, nil
+}
 
-	users := make([]models.User, 0)
-	var err error
-	if options.Limit != 0 && options.Since != "" {
-		err := p.DB.Select(&users, query, slug, options.Since, options.Limit)
+// GetForumChildren lists the subforums of slug that viewer may read.
+func (p *Postgres) GetForumChildren(slug, viewer string) ([]models.Forum, error) {
+	query := `SELECT ` + forumColumns + ` FROM forums WHERE parent = $1 AND ` + policy.ReadableBy("forums", "$2") + ` ORDER BY category, slug`
+	forums := make([]models.Forum, 0)
+	err := p.DB.Select(&forums, query, slug, viewer)
+	return forums, err
+}
+
+// GetForumBreadcrumbs returns the ancestors of the forum, root first.
+func (p *Postgres) GetForumBreadcrumbs(slug string) ([]models.ForumCrumb, error) {
+	query := `WITH RECURSIVE chain(slug, title, parent, depth) AS (
+			SELECT slug, title, parent, 0 FROM forums WHERE slug = $1
+			UNION ALL
+			SELECT forums.slug, forums.title, forums.parent, chain.depth + 1
+			FROM forums JOIN chain ON forums.slug = chain.parent
+		)
+		SELECT slug, title FROM chain WHERE depth > 0 ORDER BY depth DESC`
+	crumbs := make([]models.ForumCrumb, 0)
+	err := p.DB.Select(&crumbs, query, slug)
+	return crumbs, err
+}
+
+// MoveForum puts the forum under parent, or at the top level when parent is
+// empty, and carries its totals from the old chain of ancestors to the new
+// one. Moves are serialized so two of them cannot close a cycle together.
+func (p *Postgres) MoveForum(slug, parent, actor string) (models.Forum, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Forum{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('forum_move'))`); err != nil {
+		return models.Forum{}, err
+	}
+
+	var forum models.Forum
+	err = tx.Get(&forum, `SELECT `+forumColumns+` FROM forums WHERE slug = $1 FOR UPDATE`, slug)
+	if err != nil {
+		return models.Forum{}, err
+	}
+
+	if parent != "" {
+		var cycle bool
+		query := `SELECT EXISTS (SELECT 1 FROM forum_ancestors($1) ancestor WHERE ancestor = $2::citext)`
+		if err = tx.Get(&cycle, query, parent, forum.Slug); err != nil {
+			return models.Forum{}, err
+		}
+		if cycle {
+			return models.Forum{}, errors.ErrForumCycle
+		}
+	}
+
+	shift := `UPDATE forums SET total_posts = total_posts + $2, total_threads = total_threads + $3
+		WHERE slug IN (SELECT forum_ancestors($1))`
+	if forum.Parent != "" {
+		_, err = tx.Exec(shift, forum.Parent, -int64(forum.TotalPosts), -int64(forum.TotalThreads))
 		if err != nil {
-			return nil, err
+			return models.Forum{}, err
+		}
+	}
+
+	var res models.Forum
+	query := `UPDATE forums SET parent = NULLIF($2, '')::citext WHERE slug = $1 RETURNING ` + forumColumns
+	err = tx.QueryRowx(query, forum.Slug, parent).StructScan(&res)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return models.Forum{}, errors.ErrParentNotFound
 		}
-	} else if options.Limit != 0 && options.Since == "" {
-		err := p.DB.Select(&users, query, slug, options.Limit)
+		return models.Forum{}, err
+	}
+
+	if res.Parent != "" {
+		_, err = tx.Exec(shift, res.Parent, int64(res.TotalPosts), int64(res.TotalThreads))
 		if err != nil {
-			return nil, err
+			return models.Forum{}, err
 		}
 	}
 
-	return users, err
+	details := map[string]string{"from": forum.Parent, "to": res.Parent}
+	if err = audit.Record(tx, actor, "forum.move", res.Slug, details); err != nil {
+		return models.Forum{}, err
+	}
+	return res, tx.Commit()
+}
+
+func (p *Postgres) UpdateForumSettings(slug string, settings models.ForumSettings, actor string) (models.Forum, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Forum{}, err
+	}
+	defer tx.Rollback()
+
+	var res models.Forum
+	query := `UPDATE forums SET visibility = $2, posting = $3, thread_creation = $4 WHERE slug = $1 RETURNING ` + forumColumns
+	err = tx.QueryRowx(query, slug, settings.Visibility, settings.Posting, settings.ThreadCreation).StructScan(&res)
+	if err != nil {
+		return models.Forum{}, err
+	}
+
+	if err = audit.Record(tx, actor, "forum.settings", res.Slug, settings); err != nil {
+		return models.Forum{}, err
+	}
+	return res, tx.Commit()
+}
+
+// GetMemberState returns the membership state of nickname, empty when there
+// is no membership at all.
+func (p *Postgres) GetMemberState(forum, nickname string) (string, error) {
+	var state string
+	err := p.DB.Get(&state, `SELECT state FROM forum_members WHERE forum = $1 AND nickname = $2`, forum, nickname)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return state, err
+}
+
+func (p *Postgres) SetMemberState(forum, nickname, state string) (models.ForumMembership, error) {
+	var res models.ForumMembership
+	query := `INSERT INTO forum_members (forum, nickname, state) VALUES ($1, $2, $3)
+		ON CONFLICT (forum, nickname) DO UPDATE SET state = excluded.state
+		RETURNING forum, nickname, state, created`
+	err := p.DB.QueryRowx(query, forum, nickname, state).StructScan(&res)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return models.ForumMembership{}, errors.ErrUserNotFound
+		}
+		return models.ForumMembership{}, err
+	}
+	return res, nil
+}
+
+func (p *Postgres) RemoveMember(forum, nickname string) error {
+	_, err := p.DB.Exec(`DELETE FROM forum_members WHERE forum = $1 AND nickname = $2`, forum, nickname)
+	return err
+}
+
+func (p *Postgres) GetMembers(forum, state string) ([]models.ForumMembership, error) {
+	query := `SELECT forum, nickname, state, created FROM forum_members WHERE forum = $1 AND state = $2 ORDER BY nickname`
+	members := make([]models.ForumMembership, 0)
+	err := p.DB.Select(&members, query, forum, state)
+	return members, err
+}
+
+// GetActivity sums the hourly rollups into options.Bucket sized buckets in
+// UTC, with empty buckets filled in. An empty forum covers the whole service.
+func (p *Postgres) GetActivity(forum string, options models.ActivityOptions) ([]models.ActivityBucket, error) {
+	scope := ``
+	args := []interface{}{options.Bucket, options.From, options.To}
+	if forum != "" {
+		scope = ` AND forum = $4`
+		args = append(args, forum)
+	}
+
+	query := `WITH series AS (
+			SELECT start FROM generate_series(date_trunc($1, $2::timestamptz, 'UTC'), $3::timestamptz, ('1 ' || $1)::interval) start
+			WHERE start < $3
+		), activity AS (
+			SELECT date_trunc($1, bucket, 'UTC') AS start, SUM(threads) AS threads, SUM(posts) AS posts, SUM(votes) AS votes
+			FROM forum_activity_hourly
+			WHERE bucket >= date_trunc($1, $2::timestamptz, 'UTC') AND bucket < $3` + scope + `
+			GROUP BY 1
+		), authors AS (
+			SELECT date_trunc($1, bucket, 'UTC') AS start, COUNT(DISTINCT author) AS authors
+			FROM forum_activity_authors
+			WHERE bucket >= date_trunc($1, $2::timestamptz, 'UTC') AND bucket < $3` + scope + `
+			GROUP BY 1
+		)
+		SELECT series.start, COALESCE(activity.threads, 0) AS threads, COALESCE(activity.posts, 0) AS posts,
+			COALESCE(authors.authors, 0) AS authors, COALESCE(activity.votes, 0) AS votes
+		FROM series
+		LEFT JOIN activity ON activity.start = series.start
+		LEFT JOIN authors ON authors.start = series.start
+		ORDER BY series.start`
+
+	buckets := make([]models.ActivityBucket, 0)
+	err := p.DB.Select(&buckets, query, args...)
+	return buckets, err
 }
@@ -2,31 +2,68 @@ package usecase
 
 import (
 	"database/sql"
-	"technopark_db_forum/internal/forum/repository"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	forumRepository "technopark_db_forum/internal/forum/repository"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
 	"technopark_db_forum/internal/users/repository"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/slug"
+	"technopark_db_forum/pkg/timeseries"
+	"time"
 )
 
 type ForumUsecase interface {
 	CreateForum(forum models.Forum) (models.Forum, error)
-	GetForumBySlug(slug string) (models.Forum, error)
-	GetForumUsersBySlug(slug string, options models.ThreadOptions) ([]models.User, error)
+	GetForumBySlug(slug, viewer string) (models.Forum, error)
+	GetForumUsersBySlug(slug string, options models.ForumUsersOptions, cursor string) ([]models.ForumMember, string, error)
+
+	GetModerators(slug string) ([]string, error)
+	AddModerator(slug, nickname, actor string) ([]string, error)
+	RemoveModerator(slug, nickname, actor string) ([]string, error)
+	BanUser(slug, nickname, actor string) error
+	UnbanUser(slug, nickname, actor string) error
+	ClearForum(slug, actor string) (models.Forum, error)
+
+	UpdateForum(slug string, update models.ForumUpdate, actor string) (models.Forum, error)
+	DeleteForum(slug, actor string, archive bool) (models.Forum, error)
+
+	GetForums(options models.ForumListOptions) ([]models.ForumSummary, error)
+
+	GetForumChildren(slug, viewer string) ([]models.Forum, error)
+	MoveForum(slug, parent, actor string) (models.Forum, error)
+
+	UpdateSettings(slug string, settings models.ForumSettings, actor string) (models.Forum, error)
+	Join(slug, actor string) (models.ForumMembership, error)
+	AddMember(slug, nickname, actor string) (models.ForumMembership, error)
+	RemoveMember(slug, nickname, actor string) error
+	GetMembers(slug, state, actor string) ([]models.ForumMembership, error)
+
+	GetActivity(slug, viewer string, options models.ActivityOptions) ([]models.ActivityBucket, error)
 }
 
 type usecase struct {
 	forumRepository forumRepository.ForumRepository
 	userRepository  userRepository.UserRepository
+	policy          policy.Enforcer
 }
 
 func NewUserUsecase(forumRepo forumRepository.ForumRepository, userRepo userRepository.UserRepository) ForumUsecase {
 	return &usecase{
 		forumRepository: forumRepo,
 		userRepository:  userRepo,
+		policy:          policy.NewEnforcer(userRepo, forumRepo),
 	}
 }
 
 func (u usecase) CreateForum(forum models.Forum) (models.Forum, error) {
+	if !slug.Valid(forum.Slug) {
+		return models.Forum{}, e.ErrInvalidSlug
+	}
+
 	user, err := u.userRepository.GetUserByNickname(forum.UserNickname)
 	if err != nil {
 		return models.Forum{}, err
@@ -40,6 +77,17 @@ func (u usecase) CreateForum(forum models.Forum) (models.Forum, error) {
 		return f, e.ErrDuplicate
 	}
 
+	if forum.Parent != "" {
+		parent, err := u.forumRepository.GetForumBySlug(forum.Parent)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return models.Forum{}, e.ErrParentNotFound
+			}
+			return models.Forum{}, err
+		}
+		forum.Parent = parent.Slug
+	}
+
 	forum.UserNickname = user.Nickname
 	res, err := u.forumRepository.CreateForum(forum)
 	if err != nil {
@@ -51,23 +99,407 @@ func (u usecase) CreateForum(forum models.Forum) (models.Forum, error) {
 	return res, nil
 }
 
-func (u usecase) GetForumBySlug(slug string) (models.Forum, error) {
+func (u usecase) GetForumBySlug(slug, viewer string) (models.Forum, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.Forum{}, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return models.Forum{}, err
+	}
+	if forum.Parent != "" {
+		forum.Breadcrumbs, err = u.forumRepository.GetForumBreadcrumbs(forum.Slug)
+		if err != nil {
+			return models.Forum{}, err
+		}
+	}
+	return forum, nil
+}
+
+// GetForumUsersBySlug returns a page of forum members and, when the page is
+// full, the cursor of the next one.
+func (u usecase) GetForumUsersBySlug(slug string, options models.ForumUsersOptions, cursor string) ([]models.ForumMember, string, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = u.policy.AuthorizeAccess(options.Viewer, forum, policy.Read); err != nil {
+		return nil, "", err
+	}
+
+	if cursor != "" {
+		options.Cursor, err = decodeMemberCursor(cursor, options.SortBy)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	members, err := u.forumRepository.GetForumUsers(forum.Slug, options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if options.Limit != 0 && uint64(len(members)) == options.Limit {
+		next = encodeMemberCursor(members[len(members)-1], options.SortBy)
+	}
+	return members, next, nil
+}
+
+// encodeMemberCursor packs the sort key, its value and the nickname so a
+// cursor can't be replayed against another sort order.
+func encodeMemberCursor(member models.ForumMember, sortBy string) string {
+	var value int64
+	switch sortBy {
+	case "posts":
+		value = int64(member.Posts)
+	case "karma":
+		value = member.Karma
+	case "activity":
+		value = member.LastSeen.UnixNano()
+	}
+	raw := fmt.Sprintf("%s:%d:%s", sortBy, value, member.Nickname)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMemberCursor(cursor, sortBy string) (*models.ForumMemberCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, e.ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || parts[0] != sortBy || parts[2] == "" {
+		return nil, e.ErrInvalidCursor
+	}
+	value, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, e.ErrInvalidCursor
+	}
+
+	res := &models.ForumMemberCursor{Count: value, Nickname: parts[2]}
+	if sortBy == "activity" {
+		res.Seen = time.Unix(0, value)
+	}
+	return res, nil
+}
+
+func (u usecase) GetModerators(slug string) ([]string, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	return u.forumRepository.GetForumModerators(forum.Slug)
+}
+
+func (u usecase) AddModerator(slug, nickname, actor string) ([]string, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.ManageModerators, ""); err != nil {
+		return nil, err
+	}
+
+	if err = u.forumRepository.AddForumModerator(forum.Slug, nickname, actor); err != nil {
+		return nil, err
+	}
+	return u.forumRepository.GetForumModerators(forum.Slug)
+}
+
+func (u usecase) RemoveModerator(slug, nickname, actor string) ([]string, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.ManageModerators, ""); err != nil {
+		return nil, err
+	}
+
+	if err = u.forumRepository.RemoveForumModerator(forum.Slug, nickname, actor); err != nil {
+		return nil, err
+	}
+	return u.forumRepository.GetForumModerators(forum.Slug)
+}
+
+func (u usecase) BanUser(slug, nickname, actor string) error {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.BanUser, ""); err != nil {
+		return err
+	}
+	return u.forumRepository.BanUser(forum.Slug, nickname, actor)
+}
+
+func (u usecase) UnbanUser(slug, nickname, actor string) error {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.BanUser, ""); err != nil {
+		return err
+	}
+	return u.forumRepository.UnbanUser(forum.Slug, nickname, actor)
+}
+
+func (u usecase) ClearForum(slug, actor string) (models.Forum, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.Forum{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.ClearForum, ""); err != nil {
+		return models.Forum{}, err
+	}
+
+	if err = u.forumRepository.ClearForum(forum.Slug, actor); err != nil {
+		return models.Forum{}, err
+	}
+	return u.forumRepository.GetForumBySlug(forum.Slug)
+}
+
+func (u usecase) UpdateForum(slug string, update models.ForumUpdate, actor string) (models.Forum, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.Forum{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.EditForum, ""); err != nil {
+		return models.Forum{}, err
+	}
+
+	if update.Title != "" {
+		forum.Title = update.Title
+	}
+	if update.UserNickname != "" {
+		owner, err := u.userRepository.GetUserByNickname(update.UserNickname)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return models.Forum{}, e.ErrUserNotFound
+			}
+			return models.Forum{}, err
+		}
+		forum.UserNickname = owner.Nickname
+	}
+	if update.Archived != nil {
+		forum.Archived = *update.Archived
+	}
+	if update.Category != nil {
+		forum.Category = *update.Category
+	}
+
+	return u.forumRepository.UpdateForum(forum, actor)
+}
+
+// DeleteForum archives the forum when archive is set, keeping its content
+// readable but closed for writes, and removes it with everything in it
+// otherwise.
+func (u usecase) DeleteForum(slug, actor string, archive bool) (models.Forum, error) {
 	forum, err := u.forumRepository.GetForumBySlug(slug)
 	if err != nil {
 		return models.Forum{}, err
 	}
+	if err = u.policy.Authorize(actor, forum, policy.DeleteForum, ""); err != nil {
+		return models.Forum{}, err
+	}
+
+	if archive {
+		forum.Archived = true
+		return u.forumRepository.UpdateForum(forum, actor)
+	}
+	if err = u.forumRepository.DeleteForum(forum, actor); err != nil {
+		return models.Forum{}, err
+	}
 	return forum, nil
 }
 
-func (u usecase) GetForumUsersBySlug(slug string, options models.ThreadOptions) ([]models.User, error) {
-	_, err := u.forumRepository.GetForumBySlug(slug)
+func (u usecase) GetForums(options models.ForumListOptions) ([]models.ForumSummary, error) {
+	return u.forumRepository.GetForums(options)
+}
+
+// GetForumChildren lists the subforums of a forum the viewer can read,
+// leaving out the members-only ones the viewer can't.
+func (u usecase) GetForumChildren(slug, viewer string) ([]models.Forum, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
 	if err != nil {
-		return []models.User{}, err
+		return nil, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return nil, err
+	}
+	return u.forumRepository.GetForumChildren(forum.Slug, viewer)
+}
+
+func (u usecase) MoveForum(slug, parent, actor string) (models.Forum, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.Forum{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.MoveForum, ""); err != nil {
+		return models.Forum{}, err
+	}
+
+	if parent != "" {
+		target, err := u.forumRepository.GetForumBySlug(parent)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return models.Forum{}, e.ErrParentNotFound
+			}
+			return models.Forum{}, err
+		}
+		parent = target.Slug
+	}
+	return u.forumRepository.MoveForum(forum.Slug, parent, actor)
+}
+
+func validSettings(settings models.ForumSettings) bool {
+	switch settings.Visibility {
+	case models.VisibilityPublic, models.VisibilityMembers:
+	default:
+		return false
+	}
+	switch settings.Posting {
+	case models.PostingOpen, models.PostingReadOnly, models.PostingModerated:
+	default:
+		return false
+	}
+	switch settings.ThreadCreation {
+	case models.ThreadCreationAnyone, models.ThreadCreationMembers, models.ThreadCreationModerators:
+	default:
+		return false
+	}
+	return true
+}
+
+func (u usecase) UpdateSettings(slug string, settings models.ForumSettings, actor string) (models.Forum, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.Forum{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.EditForum, ""); err != nil {
+		return models.Forum{}, err
+	}
+
+	current := forum.ForumSettings
+	if settings.Visibility == "" {
+		settings.Visibility = current.Visibility
+	}
+	if settings.Posting == "" {
+		settings.Posting = current.Posting
+	}
+	if settings.ThreadCreation == "" {
+		settings.ThreadCreation = current.ThreadCreation
+	}
+	if !validSettings(settings) {
+		return models.Forum{}, e.ErrInvalidSettings
+	}
+	return u.forumRepository.UpdateForumSettings(forum.Slug, settings, actor)
+}
+
+// Join makes actor a member of the forum. Public forums and pending invites
+// are accepted right away; members-only forums otherwise get a join request
+// that staff has to approve with AddMember.
+func (u usecase) Join(slug, actor string) (models.ForumMembership, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.ForumMembership{}, err
+	}
+	user, err := u.userRepository.GetUserByNickname(actor)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.ForumMembership{}, e.ErrActorRequired
+		}
+		return models.ForumMembership{}, err
+	}
+
+	banned, err := u.forumRepository.IsBanned(forum.Slug, user.Nickname)
+	if err != nil {
+		return models.ForumMembership{}, err
+	}
+	if banned {
+		return models.ForumMembership{}, e.ErrBanned
+	}
+
+	state, err := u.forumRepository.GetMemberState(forum.Slug, user.Nickname)
+	if err != nil {
+		return models.ForumMembership{}, err
+	}
+	switch {
+	case state == models.MemberInvited || forum.Visibility != models.VisibilityMembers:
+		state = models.MemberActive
+	case state == "":
+		state = models.MemberPending
+	}
+	return u.forumRepository.SetMemberState(forum.Slug, user.Nickname, state)
+}
+
+// AddMember invites nickname, or approves their pending join request.
+func (u usecase) AddMember(slug, nickname, actor string) (models.ForumMembership, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return models.ForumMembership{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.ManageMembers, ""); err != nil {
+		return models.ForumMembership{}, err
+	}
+
+	state, err := u.forumRepository.GetMemberState(forum.Slug, nickname)
+	if err != nil {
+		return models.ForumMembership{}, err
+	}
+	switch state {
+	case models.MemberPending:
+		state = models.MemberActive
+	case "":
+		state = models.MemberInvited
+	}
+	return u.forumRepository.SetMemberState(forum.Slug, nickname, state)
+}
+
+// RemoveMember lets a member leave or staff remove anyone, including
+// declining join requests and withdrawing invites.
+func (u usecase) RemoveMember(slug, nickname, actor string) error {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(nickname, actor) {
+		if err = u.policy.Authorize(actor, forum, policy.ManageMembers, ""); err != nil {
+			return err
+		}
+	}
+	return u.forumRepository.RemoveMember(forum.Slug, nickname)
+}
+
+// GetMembers lists members in state. Active members are visible to whoever
+// can read the forum; requests and invites only to staff.
+func (u usecase) GetMembers(slug, state, actor string) ([]models.ForumMembership, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if state == models.MemberActive {
+		err = u.policy.AuthorizeAccess(actor, forum, policy.Read)
+	} else {
+		err = u.policy.Authorize(actor, forum, policy.ManageMembers, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u.forumRepository.GetMembers(forum.Slug, state)
+}
+
+func (u usecase) GetActivity(slug, viewer string, options models.ActivityOptions) ([]models.ActivityBucket, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return nil, err
 	}
 
-	users, err := u.forumRepository.GetForumUsers(slug, options)
+	options.From, options.To, err = timeseries.Window(options.From, options.To, options.Bucket, time.Now())
 	if err != nil {
-		return []models.User{}, err
+		return nil, err
 	}
-	return users, nil
+	return u.forumRepository.GetActivity(forum.Slug, options)
 }
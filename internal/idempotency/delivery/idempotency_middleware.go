@@ -0,0 +1,109 @@
+package delivery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"technopark_db_forum/internal/idempotency/usecase"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/logger"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	HeaderReplayed       = "Idempotent-Replayed"
+)
+
+// recorder copies everything written to the client so it can be stored.
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware makes a create endpoint idempotent for requests carrying an
+// Idempotency-Key header. Requests without the header pass through untouched.
+func Middleware(idempotencyUsecase usecase.IdempotencyUsecase) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			key := req.Header.Get(HeaderIdempotencyKey)
+			if key == "" {
+				return next(c)
+			}
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			hash.Write([]byte(req.Method + " " + req.URL.Path + "?" + req.URL.RawQuery + "\n"))
+			hash.Write(body)
+
+			stored, err := idempotencyUsecase.Begin(key, hex.EncodeToString(hash.Sum(nil)))
+			switch err {
+			case nil:
+			case e.ErrIdempotencyMismatch:
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			case e.ErrIdempotencyInFlight:
+				return echo.NewHTTPError(http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+			default:
+				return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+
+			res := c.Response()
+			if stored != nil {
+				res.Header().Set(HeaderReplayed, "true")
+				return c.Blob(stored.Status, stored.ContentType, stored.Body)
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					_ = idempotencyUsecase.Complete(key, models.IdempotentResponse{Status: http.StatusInternalServerError})
+					panic(r)
+				}
+			}()
+
+			rec := &recorder{ResponseWriter: res.Writer}
+			res.Writer = rec
+			err = next(c)
+			if err != nil {
+				// Render the error now so it is recorded; the outer error
+				// handler sees a committed response and leaves it alone.
+				c.Error(err)
+			}
+			res.Writer = rec.ResponseWriter
+
+			status := res.Status
+			if !res.Committed {
+				status = http.StatusInternalServerError
+			}
+			completeErr := idempotencyUsecase.Complete(key, models.IdempotentResponse{
+				Status:      status,
+				ContentType: res.Header().Get(echo.HeaderContentType),
+				Body:        rec.body.Bytes(),
+			})
+			if completeErr != nil {
+				logger.GetInstance().Errorf("idempotency key %s: %s", key, completeErr)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package idempotencyRepository
+
+import (
+	"database/sql"
+	"technopark_db_forum/internal/models"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type IdempotencyRepository interface {
+	Reserve(key, requestHash string, ttl time.Duration) (bool, error)
+	GetRecord(key string) (models.IdempotencyRecord, error)
+	SaveResponse(key string, response models.IdempotentResponse) error
+	Release(key string) error
+	Purge(ttl time.Duration) (int64, error)
+}
+
+type Postgres struct {
+	DB *sqlx.DB
+}
+
+func NewPostgres(url string) (*Postgres, error) {
+	db, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+	return &Postgres{DB: db}, nil
+}
+
+// Reserve claims key for a new request. A key older than ttl is treated as
+// free and taken over. It reports false when the key is held by a live record.
+func (p Postgres) Reserve(key, requestHash string, ttl time.Duration) (bool, error) {
+	query := `
+		INSERT INTO idempotency_keys (key, request_hash) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = NULL, content_type = NULL, body = NULL, created = NOW()
+		WHERE idempotency_keys.created < NOW() - make_interval(secs => $3)
+		RETURNING key
+	`
+	var reserved string
+	err := p.DB.Get(&reserved, query, key, requestHash, ttl.Seconds())
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p Postgres) GetRecord(key string) (models.IdempotencyRecord, error) {
+	var row struct {
+		RequestHash string         `db:"request_hash"`
+		Status      sql.NullInt64  `db:"status"`
+		ContentType sql.NullString `db:"content_type"`
+		Body        []byte         `db:"body"`
+	}
+	query := `SELECT request_hash, status, content_type, body FROM idempotency_keys WHERE key = $1`
+	if err := p.DB.Get(&row, query, key); err != nil {
+		return models.IdempotencyRecord{}, err
+	}
+
+	return models.IdempotencyRecord{
+		RequestHash: row.RequestHash,
+		Done:        row.Status.Valid,
+		Response: models.IdempotentResponse{
+			Status:      int(row.Status.Int64),
+			ContentType: row.ContentType.String,
+			Body:        row.Body,
+		},
+	}, nil
+}
+
+func (p Postgres) SaveResponse(key string, response models.IdempotentResponse) error {
+	query := `UPDATE idempotency_keys SET status = $2, content_type = $3, body = $4 WHERE key = $1`
+	_, err := p.DB.Exec(query, key, response.Status, response.ContentType, response.Body)
+	return err
+}
+
+func (p Postgres) Release(key string) error {
+	_, err := p.DB.Exec(`DELETE FROM idempotency_keys WHERE key = $1`, key)
+	return err
+}
+
+// Purge deletes keys older than ttl, which Reserve would take over anyway.
+func (p Postgres) Purge(ttl time.Duration) (int64, error) {
+	res, err := p.DB.Exec(`DELETE FROM idempotency_keys WHERE created < NOW() - make_interval(secs => $1)`, ttl.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,84 @@
+package usecase
+
+import (
+	"database/sql"
+	idempotencyRepository "technopark_db_forum/internal/idempotency/repository"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/logger"
+	"time"
+)
+
+type IdempotencyUsecase interface {
+	Begin(key, requestHash string) (*models.IdempotentResponse, error)
+	Complete(key string, response models.IdempotentResponse) error
+	RunPurge(interval time.Duration, stop <-chan struct{})
+}
+
+type usecase struct {
+	idempotencyRepository idempotencyRepository.IdempotencyRepository
+	ttl                   time.Duration
+}
+
+func NewIdempotencyUsecase(idempotencyRepo idempotencyRepository.IdempotencyRepository, ttl time.Duration) IdempotencyUsecase {
+	return &usecase{
+		idempotencyRepository: idempotencyRepo,
+		ttl:                   ttl,
+	}
+}
+
+// Begin either reserves key for this request (nil, nil), returns the stored
+// response to replay, or fails with ErrIdempotencyMismatch when the key was
+// used with a different payload, or ErrIdempotencyInFlight while the first
+// request has not finished yet.
+func (u usecase) Begin(key, requestHash string) (*models.IdempotentResponse, error) {
+	reserved, err := u.idempotencyRepository.Reserve(key, requestHash, u.ttl)
+	if err != nil {
+		return nil, err
+	}
+	if reserved {
+		return nil, nil
+	}
+
+	record, err := u.idempotencyRepository.GetRecord(key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Released between the two statements, start over.
+			return u.Begin(key, requestHash)
+		}
+		return nil, err
+	}
+	if record.RequestHash != requestHash {
+		return nil, e.ErrIdempotencyMismatch
+	}
+	if !record.Done {
+		return nil, e.ErrIdempotencyInFlight
+	}
+	return &record.Response, nil
+}
+
+// Complete stores the first response. Server errors are not stored, the key
+// is released instead so the client can retry for real.
+func (u usecase) Complete(key string, response models.IdempotentResponse) error {
+	if response.Status >= 500 {
+		return u.idempotencyRepository.Release(key)
+	}
+	return u.idempotencyRepository.SaveResponse(key, response)
+}
+
+// RunPurge deletes expired keys every interval until stop is closed.
+func (u usecase) RunPurge(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := u.idempotencyRepository.Purge(u.ttl); err != nil {
+				logger.GetInstance().Errorf("purge idempotency keys: %s", err)
+			}
+		}
+	}
+}
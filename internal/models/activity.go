@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// ActivityOptions selects a forum activity time series. Bucket is one of the
+// timeseries bucket names.
+type ActivityOptions struct {
+	From   time.Time
+	To     time.Time
+	Bucket string
+}
+
+// ActivityBucket is one point of the series, starting at Start (UTC).
+type ActivityBucket struct {
+	Start   time.Time `json:"start" db:"start"`
+	Threads uint64    `json:"threads" db:"threads"`
+	Posts   uint64    `json:"posts" db:"posts"`
+	Authors uint64    `json:"authors" db:"authors"`
+	Votes   uint64    `json:"votes" db:"votes"`
+}
@@ -0,0 +1,39 @@
+package models
+
+const (
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatZIP    = "zip"
+)
+
+const (
+	ExportProfile = "profile"
+	ExportThread  = "thread"
+	ExportPost    = "post"
+	ExportVote    = "vote"
+	ExportForum   = "forum"
+)
+
+// ExportRecord is a single line of a personal data export.
+type ExportRecord struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type ExportThreadData struct {
+	Thread
+	ForumTitle string `json:"forum_title"`
+}
+
+type ExportPostData struct {
+	Post
+	ForumTitle  string `json:"forum_title"`
+	ThreadTitle string `json:"thread_title"`
+	ThreadSlug  string `json:"thread_slug"`
+}
+
+type ExportVoteData struct {
+	ThreadID    uint64 `json:"thread"`
+	ThreadTitle string `json:"thread_title"`
+	Forum       string `json:"forum"`
+	VoiceValue  int64  `json:"voice"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+const (
+	FeedKindThread = "thread"
+	FeedKindPost   = "post"
+)
+
+type Follow struct {
+	User  string `json:"user,omitempty"`
+	Forum string `json:"forum,omitempty"`
+}
+
+type Following struct {
+	Users  []string `json:"users"`
+	Forums []string `json:"forums"`
+}
+
+type FeedItem struct {
+	Kind     string    `json:"kind" db:"kind"`
+	ID       uint64    `json:"id" db:"id"`
+	ThreadID uint64    `json:"thread" db:"thread"`
+	Author   string    `json:"author" db:"author"`
+	Forum    string    `json:"forum" db:"forum"`
+	Title    string    `json:"title,omitempty" db:"title"`
+	Message  string    `json:"message" db:"message"`
+	Created  time.Time `json:"created" db:"created"`
+}
+
+// FeedCursor points at the last item of a feed page; the zero value starts
+// from the newest item.
+type FeedCursor struct {
+	Created time.Time
+	ID      uint64
+	Kind    string
+}
+
+type FeedPage struct {
+	Items []FeedItem `json:"items"`
+	Next  string     `json:"next,omitempty"`
+}
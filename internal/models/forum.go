@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Forum struct {
 	Slug         string `json:"slug" db:"slug"`
 	Title        string `json:"title" db:"title"`
@@ -7,6 +9,131 @@ type Forum struct {
 
 	PostsCount   uint64 `json:"posts" db:"posts"`
 	ThreadsCount uint64 `json:"threads" db:"threads"`
+	Archived     bool   `json:"archived,omitempty" db:"archived"`
+
+	Parent       string       `json:"parent,omitempty" db:"parent"`
+	Category     string       `json:"category,omitempty" db:"category"`
+	TotalPosts   uint64       `json:"total_posts" db:"total_posts"`
+	TotalThreads uint64       `json:"total_threads" db:"total_threads"`
+	Breadcrumbs  []ForumCrumb `json:"breadcrumbs,omitempty" db:"-"`
+
+	ForumSettings
+}
+
+const (
+	VisibilityPublic  = "public"
+	VisibilityMembers = "members"
+
+	PostingOpen      = "open"
+	PostingReadOnly  = "read_only"
+	PostingModerated = "moderated"
+
+	ThreadCreationAnyone     = "anyone"
+	ThreadCreationMembers    = "members"
+	ThreadCreationModerators = "moderators"
+)
+
+// ForumSettings controls who may read and write in a forum. In updates an
+// empty field is left as is.
+type ForumSettings struct {
+	Visibility     string `json:"visibility" db:"visibility"`
+	Posting        string `json:"posting" db:"posting"`
+	ThreadCreation string `json:"thread_creation" db:"thread_creation"`
+}
+
+const (
+	MemberPending = "pending"
+	MemberInvited = "invited"
+	MemberActive  = "active"
+)
+
+type ForumMembership struct {
+	Forum    string    `json:"forum" db:"forum"`
+	Nickname string    `json:"nickname" db:"nickname"`
+	State    string    `json:"state" db:"state"`
+	Created  time.Time `json:"created" db:"created"`
+}
+
+// ForumCrumb is one ancestor on the path from the root forum down.
+type ForumCrumb struct {
+	Slug  string `json:"slug" db:"slug"`
+	Title string `json:"title" db:"title"`
+}
+
+// ForumMove reparents a forum; an empty parent makes it top-level.
+type ForumMove struct {
+	Parent string `json:"parent"`
+}
+
+// ForumUpdate holds the editable forum fields; empty fields are left as is.
+type ForumUpdate struct {
+	Title        string  `json:"title"`
+	UserNickname string  `json:"user"`
+	Archived     *bool   `json:"archived"`
+	Category     *string `json:"category"`
+}
+
+// ForumSummary is a forum as shown in the forum listing.
+type ForumSummary struct {
+	Forum
+	LastThread   *ForumLastThread `json:"last_thread,omitempty"`
+	LastActivity time.Time        `json:"last_activity"`
+}
+
+type ForumLastThread struct {
+	Title   string    `json:"title"`
+	Created time.Time `json:"created"`
+}
+
+// ForumListOptions selects a page of the forum listing. Since is the slug of
+// the last forum on the previous page; members-only forums are listed only
+// when Viewer may read them.
+type ForumListOptions struct {
+	Limit    uint64
+	Since    string
+	Desc     bool
+	SortBy   string
+	Query    string
+	Archived bool
+	Viewer   string
+}
+
+const (
+	ForumRoleOwner       = "owner"
+	ForumRoleModerator   = "moderator"
+	ForumRoleMember      = "member"
+	ForumRoleParticipant = "participant"
+)
+
+// ForumMember is a user who wrote in a forum, with their activity there.
+type ForumMember struct {
+	User
+	Role      string    `json:"role" db:"role"`
+	FirstSeen time.Time `json:"first_seen" db:"first_seen"`
+	LastSeen  time.Time `json:"last_seen" db:"last_seen"`
+	Threads   uint64    `json:"threads" db:"threads"`
+	Posts     uint64    `json:"posts" db:"posts"`
+	Karma     int64     `json:"-" db:"karma"`
+}
+
+// ForumMemberCursor is the position after the last member of a page. Only
+// the field matching the sort order is used besides the nickname.
+type ForumMemberCursor struct {
+	Count    int64
+	Seen     time.Time
+	Nickname string
+}
+
+// ForumUsersOptions selects a page of forum members. Since is the legacy
+// nickname keyset; Cursor, when set, takes precedence.
+type ForumUsersOptions struct {
+	Limit  uint64
+	Since  string
+	Cursor *ForumMemberCursor
+	Desc   bool
+	SortBy string
+	Role   string
+	Viewer string
 }
 
 type ForumUser struct {
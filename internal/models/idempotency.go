@@ -0,0 +1,16 @@
+package models
+
+// IdempotentResponse is a stored first response for an Idempotency-Key.
+type IdempotentResponse struct {
+	Status      int    `db:"status"`
+	ContentType string `db:"content_type"`
+	Body        []byte `db:"body"`
+}
+
+// IdempotencyRecord is the state of a key: the request it was first used
+// with and, once that request finished, its response.
+type IdempotencyRecord struct {
+	RequestHash string
+	Done        bool
+	Response    IdempotentResponse
+}
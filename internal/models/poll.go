@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Poll is attached to a thread when it is created. In responses Closed and
+// the counts are filled in, Choice is the viewer's ballot and the options of
+// public polls list their voters.
+type Poll struct {
+	ID        uint64       `json:"id" db:"id"`
+	Question  string       `json:"question" db:"question"`
+	Multiple  bool         `json:"multiple" db:"multiple"`
+	Anonymous bool         `json:"anonymous" db:"anonymous"`
+	ClosesAt  *time.Time   `json:"closes_at,omitempty" db:"closes_at"`
+	Closed    bool         `json:"closed" db:"-"`
+	Voters    int64        `json:"voters" db:"voters"`
+	Options   []PollOption `json:"options" db:"-"`
+	Choice    []uint64     `json:"choice,omitempty" db:"-"`
+}
+
+type PollOption struct {
+	ID     uint64   `json:"id" db:"id"`
+	Text   string   `json:"text" db:"text"`
+	Votes  int64    `json:"votes" db:"votes"`
+	Voters []string `json:"voters,omitempty" db:"-"`
+}
+
+// Ballot picks options of a poll by id; casting it again replaces it.
+type Ballot struct {
+	Nickname string   `json:"nickname"`
+	Options  []uint64 `json:"options"`
+}
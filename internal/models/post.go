@@ -13,6 +13,7 @@ type Post struct {
 	Parent    uint64    `json:"parent,omitempty" db:"parent"`
 	Path      []uint64  `json:"path,omitempty" db:"path"`
 	TreeLevel uint64    `json:"tree_level,omitempty" db:"tree_level"`
+	Version   uint64    `json:"-" db:"version"`
 }
 
 type PostFull struct {
@@ -21,3 +22,10 @@ type PostFull struct {
 	Forum  *Forum  `json:"forum,omitempty"`
 	Thread *Thread `json:"thread,omitempty"`
 }
+
+// PostSplit moves a post and its replies into a new thread with this title,
+// in Forum or, when empty, in the forum of the post.
+type PostSplit struct {
+	Title string `json:"title"`
+	Forum string `json:"forum"`
+}
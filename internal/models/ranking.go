@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// ThreadSignals is what the ranking formulas see of a thread. RecentReplies
+// counts the replies within ranking.VelocityWindow.
+type ThreadSignals struct {
+	ID            uint64    `db:"id"`
+	Forum         string    `db:"forum"`
+	Votes         int64     `db:"votes"`
+	Upvotes       int64     `db:"upvotes"`
+	Downvotes     int64     `db:"downvotes"`
+	Replies       int64     `db:"replies"`
+	RecentReplies int64     `db:"recent_replies"`
+	Created       time.Time `db:"created"`
+	LastPostAt    time.Time `db:"last_post_at"`
+}
+
+type ThreadScore struct {
+	Thread uint64
+	Ranker string
+	Forum  string
+	Score  float64
+}
+
+type TrendingThread struct {
+	Thread
+	Score float64 `json:"score" db:"score"`
+}
+
+// TrendingOptions selects threads active within Window, from one forum or,
+// when Forum is empty, from all public forums.
+type TrendingOptions struct {
+	Forum  string
+	Ranker string
+	Window time.Duration
+	Limit  uint64
+	Viewer string
+}
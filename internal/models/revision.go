@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Revision is the content of a thread or post as of one version. Title is
+// empty for posts; Editor is empty when the edit was anonymous.
+type Revision struct {
+	Version uint64    `json:"version" db:"version"`
+	Title   string    `json:"title,omitempty" db:"title"`
+	Message string    `json:"message" db:"message"`
+	Editor  string    `json:"editor,omitempty" db:"editor"`
+	Created time.Time `json:"created" db:"created"`
+}
@@ -0,0 +1,15 @@
+package models
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+type UserRole struct {
+	Nickname string `json:"nickname"`
+	Role     string `json:"role"`
+}
+
+type ForumMemberRequest struct {
+	Nickname string `json:"nickname"`
+}
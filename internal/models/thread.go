@@ -11,6 +11,57 @@ type Thread struct {
 	Votes   int64     `json:"votes" db:"votes"`
 	Slug    string    `json:"slug" db:"slug"`
 	Created time.Time `json:"created" db:"created"`
+	Version uint64    `json:"-" db:"version"`
+
+	Status       string `json:"status" db:"status"`
+	Pinned       bool   `json:"pinned,omitempty" db:"pinned"`
+	Announcement bool   `json:"announcement,omitempty" db:"announcement"`
+
+	Replies    int64     `json:"replies" db:"replies"`
+	LastPostAt time.Time `json:"last_post_at" db:"last_post_at"`
+	Hot        float64   `json:"-" db:"hot"`
+
+	// Tags is nil when the tags were not loaded or, in updates, are to be
+	// left as is.
+	Tags []string `json:"tags,omitempty" db:"-"`
+
+	Poll *Poll `json:"poll,omitempty" db:"-"`
+
+	// Voice is set in vote responses: the voter's vote after the change.
+	Voice *int64 `json:"voice,omitempty" db:"-"`
+}
+
+const (
+	ThreadOpen   = "open"
+	ThreadLocked = "locked"
+	ThreadClosed = "closed"
+)
+
+// ThreadState changes the moderation state of a thread; nil and empty fields
+// are left as is.
+type ThreadState struct {
+	Status       string `json:"status"`
+	Pinned       *bool  `json:"pinned"`
+	Announcement *bool  `json:"announcement"`
+}
+
+type ThreadMove struct {
+	Forum string `json:"forum"`
+}
+
+// ThreadMerge names the thread, by slug or id, that takes over the posts.
+type ThreadMerge struct {
+	Into string `json:"into"`
+}
+
+// ThreadMergeResult is the target of a merge. The title and message that
+// opened the merged thread live on as OpeningPost, a root post of Thread.
+// Votes on the merged thread are not carried over: DroppedVotes of them were
+// removed, and its author's karma with them.
+type ThreadMergeResult struct {
+	Thread       Thread `json:"thread"`
+	OpeningPost  uint64 `json:"opening_post"`
+	DroppedVotes int64  `json:"dropped_votes"`
 }
 
 type ThreadNoVotes struct {
@@ -21,18 +72,78 @@ type ThreadNoVotes struct {
 	Message string `json:"message" db:"message"`
 	Slug    string `json:"slug" db:"slug"`
 	Created time.Time `json:"created" db:"created"`
+	Version uint64 `json:"-" db:"version"`
+	Tags    []string `json:"tags,omitempty" db:"-"`
 }
 
 type Vote struct {
-	ID         uint64 `json:"id" db:"id"`
+	ID         uint64 `json:"id,omitempty" db:"id"`
 	Nickname   string `json:"nickname" db:"nickname"`
 	VoiceValue int64  `json:"voice" db:"voice"`
 	ThreadID   uint64 `json:"thread" db:"thread"`
 }
 
+// VoteState is a user's vote on a thread, 0 when there is none, together with
+// the thread's score.
+type VoteState struct {
+	Thread   uint64 `json:"thread"`
+	Nickname string `json:"nickname"`
+	Voice    int64  `json:"voice"`
+	Votes    int64  `json:"votes"`
+}
+
+type VoteOptions struct {
+	Limit uint64
+	Since string
+	Desc  bool
+}
+
+const (
+	ThreadSortCreated  = "created"
+	ThreadSortVotes    = "votes"
+	ThreadSortLastPost = "last_post"
+	ThreadSortReplies  = "replies"
+	// ThreadSortHot orders by a log vote score plus creation time, a key that
+	// never decays so pages stay stable; the trending "rising" ranker is a
+	// different, time-decayed formula.
+	ThreadSortHot = "hot"
+)
+
 type ThreadOptions struct {
 	Limit  uint64
 	Since  string
 	Desc   bool
 	SortBy string
+	Viewer string
+	Tag    string
+	Cursor *ThreadCursor
+}
+
+// ThreadCursor is the position after the last thread of a page: its sort key,
+// in the field that matches ThreadOptions.SortBy, and its id. Start is the
+// position before the first regular thread, after a page that held only
+// pinned threads and announcements.
+type ThreadCursor struct {
+	Count int64
+	Score float64
+	At    time.Time
+	ID    uint64
+	Start bool
+}
+
+type TagCount struct {
+	Tag     string `json:"tag" db:"tag"`
+	Threads uint64 `json:"threads" db:"threads"`
+}
+
+// TagMerge moves every thread tagged with one of From in a forum to To. A
+// rename is a merge with a single source.
+type TagMerge struct {
+	From []string `json:"from"`
+	To   string   `json:"to"`
+}
+
+type TagRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
@@ -5,4 +5,65 @@ type User struct {
 	FullName string `json:"fullname" db:"fullname"`
 	Email    string `json:"email" db:"email"`
 	About    string `json:"about" db:"about"`
+	Version  uint64 `json:"-" db:"version"`
+}
+
+type UserRename struct {
+	Nickname string `json:"nickname"`
+}
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+const (
+	ImportStatusInvalid          = "invalid"
+	ImportStatusConflictEmail    = "conflict_email"
+	ImportStatusConflictNickname = "conflict_nickname"
+)
+
+// UserImportRow reports a row of a bulk import that was not loaded.
+type UserImportRow struct {
+	Line      int    `json:"line"`
+	Nickname  string `json:"nickname"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Conflicts []User `json:"conflicts,omitempty"`
+}
+
+type UserImportResult struct {
+	DryRun   bool            `json:"dry_run"`
+	Total    int             `json:"total"`
+	Imported int             `json:"imported"`
+	Rejected int             `json:"rejected"`
+	Rows     []UserImportRow `json:"rows"`
+	// Error is why the import stopped early; the rows above were handled.
+	Error string `json:"error,omitempty"`
+}
+
+type UserStats struct {
+	Threads uint64 `json:"threads" db:"threads"`
+	Posts   uint64 `json:"posts" db:"posts"`
+	Forums  uint64 `json:"forums" db:"forums"`
+	Karma   int64  `json:"karma" db:"karma"`
+}
+
+type UserProfile struct {
+	User
+	Stats *UserStats `json:"stats,omitempty"`
+}
+
+const (
+	RelationMute  = "mute"
+	RelationBlock = "block"
+)
+
+type RelationTarget struct {
+	User string `json:"user"`
+}
+
+type UserRelations struct {
+	Muted   []string `json:"muted"`
+	Blocked []string `json:"blocked"`
 }
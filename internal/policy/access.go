@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"fmt"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+)
+
+// Access is what a user wants to do with the content of a forum, as opposed
+// to the moderation Actions.
+type Access string
+
+const (
+	Read        Access = "read"
+	Vote        Access = "vote"
+	Post        Access = "post"
+	StartThread Access = "start_thread"
+)
+
+// Standing is a user as seen by the forum access settings. The zero value is
+// an anonymous reader.
+type Standing struct {
+	Admin  bool
+	Staff  bool
+	Member bool
+}
+
+// Permits applies the forum settings to standing. Admins pass everything;
+// moderators and the owner count as staff.
+func Permits(forum models.Forum, standing Standing, access Access) bool {
+	if standing.Admin {
+		return true
+	}
+	if forum.Visibility == models.VisibilityMembers && !standing.Member && !standing.Staff {
+		return false
+	}
+
+	switch access {
+	case Read:
+		return true
+	case Vote:
+		return forum.Posting != models.PostingReadOnly
+	case Post:
+		return canPost(forum, standing)
+	case StartThread:
+		if !canPost(forum, standing) {
+			return false
+		}
+		switch forum.ThreadCreation {
+		case models.ThreadCreationMembers:
+			return standing.Member || standing.Staff
+		case models.ThreadCreationModerators:
+			return standing.Staff
+		default:
+			return true
+		}
+	default:
+		return false
+	}
+}
+
+// ReadableBy is the SQL condition that the forum row aliased f is visible to
+// the nickname bound to placeholder viewer, as Permits decides for Read: it
+// is public, or the viewer owns or moderates it, is an active member or an
+// admin. Listings filter with it instead of checking forum by forum.
+func ReadableBy(f, viewer string) string {
+	return fmt.Sprintf(`(%[1]s.visibility = '%[3]s' OR %[1]s.user_nick = %[2]s
+		OR EXISTS (SELECT 1 FROM forum_members m WHERE m.forum = %[1]s.slug AND m.nickname = %[2]s AND m.state = '%[4]s')
+		OR EXISTS (SELECT 1 FROM forum_moderators m WHERE m.forum = %[1]s.slug AND m.nickname = %[2]s)
+		OR EXISTS (SELECT 1 FROM users a WHERE a.nickname = %[2]s AND a.role = '%[5]s'))`,
+		f, viewer, models.VisibilityPublic, models.MemberActive, models.RoleAdmin)
+}
+
+func canPost(forum models.Forum, standing Standing) bool {
+	switch forum.Posting {
+	case models.PostingReadOnly:
+		return false
+	case models.PostingModerated:
+		return standing.Staff
+	default:
+		return true
+	}
+}
+
+// Standing resolves nickname in forum. An empty or unknown nickname is an
+// anonymous reader rather than an error, so read paths can pass the viewer
+// through as given.
+func (en Enforcer) Standing(nickname string, forum models.Forum) (Standing, error) {
+	subject, err := en.Subject(nickname, forum)
+	if err == e.ErrActorRequired {
+		return Standing{}, nil
+	}
+	if err != nil {
+		return Standing{}, err
+	}
+
+	standing := Standing{
+		Admin: subject.Role == models.RoleAdmin,
+		Staff: subject.Role == models.RoleAdmin || subject.ForumOwner || subject.Moderator,
+	}
+	if en.forums != nil && !standing.Staff {
+		state, err := en.forums.GetMemberState(forum.Slug, nickname)
+		if err != nil {
+			return Standing{}, err
+		}
+		standing.Member = state == models.MemberActive
+	}
+	return standing, nil
+}
+
+// AuthorizeAccess returns ErrMembersOnly when nickname may not see the forum
+// at all and ErrPostingRestricted when it may read but not do access. Open
+// forums are decided without touching the database.
+func (en Enforcer) AuthorizeAccess(nickname string, forum models.Forum, access Access) error {
+	if Permits(forum, Standing{}, access) {
+		return nil
+	}
+
+	standing, err := en.Standing(nickname, forum)
+	if err != nil {
+		return err
+	}
+	if Permits(forum, standing, access) {
+		return nil
+	}
+	if !Permits(forum, standing, Read) {
+		return e.ErrMembersOnly
+	}
+	return e.ErrPostingRestricted
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"technopark_db_forum/internal/models"
+	"testing"
+)
+
+var standings = map[string]Standing{
+	"admin":     {Admin: true, Staff: true},
+	"owner":     {Staff: true},
+	"moderator": {Staff: true},
+	"author":    {},
+	"member":    {Member: true},
+	"anonymous": {},
+}
+
+func forum(visibility, posting, threadCreation string) models.Forum {
+	return models.Forum{Slug: "f", ForumSettings: models.ForumSettings{Visibility: visibility, Posting: posting, ThreadCreation: threadCreation}}
+}
+
+func TestPermits(t *testing.T) {
+	everyone := []string{"admin", "owner", "moderator", "author", "member", "anonymous"}
+	insiders := []string{"admin", "owner", "moderator", "member"}
+	staff := []string{"admin", "owner", "moderator"}
+	admin := []string{"admin"}
+
+	open := forum(models.VisibilityPublic, models.PostingOpen, models.ThreadCreationAnyone)
+	readOnly := forum(models.VisibilityPublic, models.PostingReadOnly, models.ThreadCreationAnyone)
+	moderated := forum(models.VisibilityPublic, models.PostingModerated, models.ThreadCreationAnyone)
+	membersThreads := forum(models.VisibilityPublic, models.PostingOpen, models.ThreadCreationMembers)
+	staffThreads := forum(models.VisibilityPublic, models.PostingOpen, models.ThreadCreationModerators)
+	private := forum(models.VisibilityMembers, models.PostingOpen, models.ThreadCreationAnyone)
+
+	tests := []struct {
+		name    string
+		forum   models.Forum
+		access  Access
+		allowed []string
+	}{
+		{"open read", open, Read, everyone},
+		{"open vote", open, Vote, everyone},
+		{"open post", open, Post, everyone},
+		{"open start thread", open, StartThread, everyone},
+
+		{"read-only read", readOnly, Read, everyone},
+		{"read-only vote", readOnly, Vote, admin},
+		{"read-only post", readOnly, Post, admin},
+		{"read-only start thread", readOnly, StartThread, admin},
+
+		{"moderated vote", moderated, Vote, everyone},
+		{"moderated post", moderated, Post, staff},
+		{"moderated start thread", moderated, StartThread, staff},
+
+		{"members threads post", membersThreads, Post, everyone},
+		{"members threads start thread", membersThreads, StartThread, insiders},
+		{"moderators threads start thread", staffThreads, StartThread, staff},
+
+		{"members-only read", private, Read, insiders},
+		{"members-only vote", private, Vote, insiders},
+		{"members-only post", private, Post, insiders},
+		{"members-only start thread", private, StartThread, insiders},
+
+		{"unknown access", open, Access("unknown"), admin},
+	}
+
+	for _, tt := range tests {
+		allowed := make(map[string]bool, len(tt.allowed))
+		for _, name := range tt.allowed {
+			allowed[name] = true
+		}
+		for name, standing := range standings {
+			if got := Permits(tt.forum, standing, tt.access); got != allowed[name] {
+				t.Errorf("%s: Permits(%s) = %v, want %v", tt.name, name, got, allowed[name])
+			}
+		}
+	}
+}
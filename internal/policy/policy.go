@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"database/sql"
+	"strings"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+)
+
+type Action string
+
+const (
+	EditPost         Action = "edit_post"
+	LockThread       Action = "lock_thread"
+	BanUser          Action = "ban_user"
+	ClearForum       Action = "clear_forum"
+	ManageModerators Action = "manage_moderators"
+	ManageRoles      Action = "manage_roles"
+	EditForum        Action = "edit_forum"
+	DeleteForum      Action = "delete_forum"
+	MoveForum        Action = "move_forum"
+	ManageMembers    Action = "manage_members"
+	ManageTags       Action = "manage_tags"
+	DeleteThread     Action = "delete_thread"
+	MoveThread       Action = "move_thread"
+	MergeThread      Action = "merge_thread"
+	SplitThread      Action = "split_thread"
+	RollbackRevision Action = "rollback_revision"
+	// ExportAccount dumps a user's email and profile, so only the user
+	// themself or an admin may do it.
+	ExportAccount Action = "export_account"
+	// Announce shows a thread in every forum, so only admins may do it.
+	Announce Action = "announce"
+)
+
+// Subject is the acting user as seen from one forum.
+type Subject struct {
+	Nickname   string
+	Role       string
+	Moderator  bool
+	ForumOwner bool
+}
+
+// Allowed decides whether subject may perform action on a resource owned by
+// owner (the post author for EditPost, the exported user for ExportAccount,
+// unused otherwise). It is a pure function so the rules can be checked
+// without a database or HTTP.
+func Allowed(subject Subject, action Action, owner string) bool {
+	if subject.Role == models.RoleAdmin {
+		return true
+	}
+
+	switch action {
+	case EditPost:
+		return (subject.Nickname != "" && strings.EqualFold(subject.Nickname, owner)) || subject.Moderator || subject.ForumOwner
+	case ExportAccount:
+		return subject.Nickname != "" && strings.EqualFold(subject.Nickname, owner)
+	case LockThread, BanUser, ManageMembers, ManageTags, DeleteThread, MoveThread, MergeThread, SplitThread, RollbackRevision:
+		return subject.Moderator || subject.ForumOwner
+	case ClearForum, ManageModerators, EditForum, DeleteForum, MoveForum:
+		return subject.ForumOwner
+	default:
+		return false
+	}
+}
+
+type RoleSource interface {
+	GetUserRole(nickname string) (string, error)
+}
+
+type ForumSource interface {
+	IsForumModerator(forum, nickname string) (bool, error)
+	GetMemberState(forum, nickname string) (string, error)
+}
+
+type Enforcer struct {
+	roles  RoleSource
+	forums ForumSource
+}
+
+// NewEnforcer builds the usecase-side policy check. forums may be nil for
+// callers that never authorize forum-scoped actions.
+func NewEnforcer(roles RoleSource, forums ForumSource) Enforcer {
+	return Enforcer{
+		roles:  roles,
+		forums: forums,
+	}
+}
+
+// Subject resolves the actor's global role and, when forum is set, their
+// standing in that forum. A missing or unknown actor is ErrActorRequired.
+func (en Enforcer) Subject(actor string, forum models.Forum) (Subject, error) {
+	if actor == "" {
+		return Subject{}, e.ErrActorRequired
+	}
+
+	role, err := en.roles.GetUserRole(actor)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Subject{}, e.ErrActorRequired
+		}
+		return Subject{}, err
+	}
+
+	subject := Subject{Nickname: actor, Role: role}
+	if forum.Slug == "" || en.forums == nil {
+		return subject, nil
+	}
+
+	subject.ForumOwner = strings.EqualFold(forum.UserNickname, actor)
+	subject.Moderator, err = en.forums.IsForumModerator(forum.Slug, actor)
+	if err != nil {
+		return Subject{}, err
+	}
+	return subject, nil
+}
+
+func (en Enforcer) Authorize(actor string, forum models.Forum, action Action, owner string) error {
+	subject, err := en.Subject(actor, forum)
+	if err != nil {
+		return err
+	}
+	if !Allowed(subject, action, owner) {
+		return e.ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"technopark_db_forum/internal/models"
+	"testing"
+)
+
+const author = "author"
+
+var subjects = map[string]Subject{
+	"admin":     {Nickname: "root", Role: models.RoleAdmin},
+	"owner":     {Nickname: "owner", Role: models.RoleUser, ForumOwner: true},
+	"moderator": {Nickname: "mod", Role: models.RoleUser, Moderator: true},
+	"author":    {Nickname: "Author", Role: models.RoleUser},
+	"member":    {Nickname: "member", Role: models.RoleUser},
+	"anonymous": {},
+}
+
+func TestAllowed(t *testing.T) {
+	moderation := []string{"admin", "owner", "moderator"}
+	ownerOnly := []string{"admin", "owner"}
+	adminOnly := []string{"admin"}
+
+	tests := []struct {
+		action  Action
+		allowed []string
+	}{
+		{EditPost, []string{"admin", "owner", "moderator", "author"}},
+		{LockThread, moderation},
+		{BanUser, moderation},
+		{ManageMembers, moderation},
+		{ManageTags, moderation},
+		{DeleteThread, moderation},
+		{MoveThread, moderation},
+		{MergeThread, moderation},
+		{SplitThread, moderation},
+		{RollbackRevision, moderation},
+		{ClearForum, ownerOnly},
+		{ManageModerators, ownerOnly},
+		{EditForum, ownerOnly},
+		{DeleteForum, ownerOnly},
+		{MoveForum, ownerOnly},
+		{ExportAccount, []string{"admin", "author"}},
+		{ManageRoles, adminOnly},
+		{Announce, adminOnly},
+		{Action("unknown"), adminOnly},
+	}
+
+	for _, tt := range tests {
+		allowed := make(map[string]bool, len(tt.allowed))
+		for _, name := range tt.allowed {
+			allowed[name] = true
+		}
+		for name, subject := range subjects {
+			if got := Allowed(subject, tt.action, author); got != allowed[name] {
+				t.Errorf("Allowed(%s, %s) = %v, want %v", name, tt.action, got, allowed[name])
+			}
+		}
+	}
+}
+
+func TestAllowedEditPostWithoutOwner(t *testing.T) {
+	// An anonymous subject must not match a resource without an owner.
+	if Allowed(subjects["anonymous"], EditPost, "") {
+		t.Fatal("anonymous subject may edit an ownerless post")
+	}
+}
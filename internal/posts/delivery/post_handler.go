@@ -9,6 +9,7 @@ import (
 	"technopark_db_forum/internal/models"
 	"technopark_db_forum/internal/posts/usecase"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/etag"
 
 	"github.com/labstack/echo/v4"
 )
@@ -40,6 +41,18 @@ func (h PostHandler) CreatePosts(c echo.Context) error {
 			return c.JSON(http.StatusNotFound, err)
 		case e.ErrOtherThread.Error():
 			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("Parent post was created in another thread"))
+		case e.ErrBanned.Error():
+			return echo.NewHTTPError(http.StatusForbidden, "Post author is banned from this forum")
+		case e.ErrForumArchived.Error():
+			return echo.NewHTTPError(http.StatusForbidden, "Forum of this thread is archived")
+		case e.ErrMembersOnly.Error(), e.ErrPostingRestricted.Error():
+			return echo.NewHTTPError(http.StatusForbidden, "Post author can't post in this forum")
+		case e.ErrThreadLocked.Error():
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is locked", slugOrID))
+		case e.ErrThreadClosed.Error():
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is closed", slugOrID))
+		case e.ErrBlocked.Error():
+			return echo.NewHTTPError(http.StatusForbidden, "Parent post author has blocked this user")
 		case e.ErrNoAuthorPost.Error():
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find post author by nickname: %s", posts[0].Author))
 		case e.ErrNoRowsID.Error():
@@ -62,15 +75,18 @@ func (h PostHandler) GetPost(c echo.Context) error {
 
 	related := strings.Split(c.QueryParam("related"), ",")
 
-	post, err := h.postUsecase.GetPostByIDRelared(id, related)
+	post, err := h.postUsecase.GetPostByIDRelared(id, related, c.QueryParam("viewer"))
 	if err != nil {
 		if err.Error() == e.ErrNotFound.Error() {
 			return c.JSON(http.StatusNotFound, err)
 		}
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, "The forum of this post is visible to members only")
+		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 
-	return c.JSON(http.StatusOK, post)
+	return etag.JSON(c, http.StatusOK, post.Post.Version, post)
 }
 
 func (h PostHandler) UpdatePost(c echo.Context) error {
@@ -85,15 +101,32 @@ func (h PostHandler) UpdatePost(c echo.Context) error {
 	}
 	post.ID = id
 
-	updatedPost, err := h.postUsecase.UpdatePost(post)
+	version, err := etag.IfMatch(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	updatedPost, err := h.postUsecase.UpdatePost(post, c.QueryParam("actor"), version)
 	if err != nil {
+		if err == e.ErrPreconditionFailed {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("Post %d was modified, refetch it and retry", id))
+		}
 		if err.Error() == sql.ErrNoRows.Error() {
 			return c.JSON(http.StatusNotFound, e.ErrNotFound)
 		}
+		if err == e.ErrActorRequired {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		}
+		if err == e.ErrForbidden {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to edit post %d", c.QueryParam("actor"), id))
+		}
+		if err == e.ErrThreadClosed {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("The thread of post %d is closed", id))
+		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 
-	return c.JSON(http.StatusOK, updatedPost)
+	return etag.JSON(c, http.StatusOK, updatedPost.Version, updatedPost)
 }
 
 func (h PostHandler) GetThreadPosts(c echo.Context) error {
@@ -116,7 +149,9 @@ func (h PostHandler) GetThreadPosts(c echo.Context) error {
 		sortBy = "flat"
 	}
 
-	posts, err := h.postUsecase.GetThreadPosts(slugOrID, limit, sortBy, since, desc)
+	viewer := c.QueryParam("viewer")
+
+	posts, err := h.postUsecase.GetThreadPosts(slugOrID, limit, sortBy, since, desc, viewer)
 	if err != nil {
 		if err.Error() == e.ErrNotFound.Error() {
 			return c.JSON(http.StatusNotFound, err)
@@ -127,8 +162,123 @@ func (h PostHandler) GetThreadPosts(c echo.Context) error {
 		if err.Error() == e.ErrNoRowsSlug.Error() {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find post thread by slug: %s", slugOrID))
 		}
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, "The forum of this thread is visible to members only")
+		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, posts)
 }
+
+// post/id/split?actor=
+func (h PostHandler) SplitPost(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	var split models.PostSplit
+	if err := c.Bind(&split); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	thread, err := h.postUsecase.SplitPost(id, split, c.QueryParam("actor"))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find post with id: %d", id))
+		case e.ErrForumNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum by slug: %s", split.Forum))
+		case e.ErrInvalidSplit:
+			return echo.NewHTTPError(http.StatusBadRequest, "title of the new thread is required")
+		case e.ErrActorRequired:
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		case e.ErrForbidden:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to split post %d", c.QueryParam("actor"), id))
+		case e.ErrForumArchived:
+			return echo.NewHTTPError(http.StatusForbidden, "Forum is archived")
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusCreated, thread)
+}
+
+// revisionError maps the errors of the revision endpoints.
+func revisionError(c echo.Context, err error, id uint64) error {
+	switch err {
+	case sql.ErrNoRows:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find post with id: %d", id))
+	case e.ErrRevisionNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find that revision of post %d", id))
+	case e.ErrMembersOnly:
+		return echo.NewHTTPError(http.StatusForbidden, "The forum of this post is visible to members only")
+	case e.ErrActorRequired:
+		return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+	case e.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to roll back post %d", c.QueryParam("actor"), id))
+	case e.ErrThreadClosed:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("The thread of post %d is closed", id))
+	default:
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+}
+
+// post/id/revisions?viewer=
+func (h PostHandler) GetRevisions(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	revisions, err := h.postUsecase.GetRevisions(id, c.QueryParam("viewer"))
+	if err != nil {
+		return revisionError(c, err, id)
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// post/id/revisions/diff?from=&to=&viewer=
+func (h PostHandler) DiffRevisions(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	var from, to uint64
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "from must be a revision version")
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "to must be a revision version")
+		}
+	}
+
+	diff, err := h.postUsecase.DiffRevisions(id, from, to, c.QueryParam("viewer"))
+	if err != nil {
+		return revisionError(c, err, id)
+	}
+	return c.String(http.StatusOK, diff)
+}
+
+// post/id/revisions/version/rollback?actor=
+func (h PostHandler) RollbackRevision(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	version, err := strconv.ParseUint(c.Param("version"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	post, err := h.postUsecase.RollbackRevision(id, version, c.QueryParam("actor"))
+	if err != nil {
+		return revisionError(c, err, id)
+	}
+	return etag.JSON(c, http.StatusOK, post.Version, post)
+}
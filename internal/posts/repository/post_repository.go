@@ -14,12 +14,13 @@ import (
 type PostRepository interface {
 	CreatePosts(post []models.Post) ([]models.Post, error)
 	GetPostByID(id uint64) (models.Post, error)
-	UpdatePost(post models.Post) (models.Post, error)
+	UpdatePost(post models.Post, version uint64, editor string) (models.Post, error)
+	GetPostRevisions(id uint64) ([]models.Revision, error)
 
 	// GetThreadPosts(id uint64, limit uint64, sort string, since uint64, desc bool) ([]models.Post, error)
-	GetThreadPostsFlat(id uint64, limit uint64, since uint64, desk bool) ([]models.Post, error)
-	GetThreadPostsTree(id uint64, limit uint64, since uint64, desk bool) ([]models.Post, error)
-	GetThreadPostsParentTree(id uint64, limit uint64, since uint64, desk bool) ([]models.Post, error)
+	GetThreadPostsFlat(id uint64, limit uint64, since uint64, desk bool, viewer string) ([]models.Post, error)
+	GetThreadPostsTree(id uint64, limit uint64, since uint64, desk bool, viewer string) ([]models.Post, error)
+	GetThreadPostsParentTree(id uint64, limit uint64, since uint64, desk bool, viewer string) ([]models.Post, error)
 }
 
 type Postgres struct {
@@ -62,24 +63,70 @@ func (p Postgres) CreatePosts(post []models.Post) ([]models.Post, error) {
 }
 
 func (p Postgres) GetPostByID(id uint64) (models.Post, error) {
-	query := `SELECT id, author, created, forum, message, parent, thread, is_edited FROM posts WHERE id = $1`
+	query := `SELECT id, author, created, forum, message, parent, thread, is_edited, version FROM posts WHERE id = $1`
 	post := models.Post{}
 	err := p.DB.Get(&post, query, id)
 	return post, err
 }
 
-func (p Postgres) UpdatePost(post models.Post) (models.Post, error) {
+// UpdatePost replaces the message and records the new revision. A non-zero
+// version makes the update conditional, see users UpdateUser.
+func (p Postgres) UpdatePost(post models.Post, version uint64, editor string) (models.Post, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Post{}, err
+	}
+	defer tx.Rollback()
+
+	// The first edit also saves the message it replaces.
+	query := `INSERT INTO post_revisions (post, version, message, editor, created)
+		SELECT id, version, message, CASE WHEN version = 1 THEN author END, CASE WHEN version = 1 THEN created ELSE NOW() END
+		FROM posts WHERE id = $1
+		ON CONFLICT DO NOTHING`
+	if _, err = tx.Exec(query, post.ID); err != nil {
+		return models.Post{}, err
+	}
+
 	var res models.Post
-	query := `UPDATE posts SET message = $1, is_edited=TRUE WHERE id = $2 RETURNING id, author, created, forum, is_edited, message, parent, thread`
-	err := p.DB.QueryRow(query, post.Message, post.ID).Scan(&res.ID, &res.Author, &res.Created, &res.Forum, &res.IsEdited, &res.Message, &res.Parent, &res.ThreadID)
-	return res, err
+	query = `UPDATE posts SET message = $1, is_edited = TRUE, version = version + 1
+		WHERE id = $2 AND ($3::bigint = 0 OR version = $3)
+		RETURNING id, author, created, forum, is_edited, message, parent, thread, version`
+	err = tx.QueryRow(query, post.Message, post.ID, version).Scan(&res.ID, &res.Author, &res.Created, &res.Forum, &res.IsEdited, &res.Message, &res.Parent, &res.ThreadID, &res.Version)
+	if err != nil {
+		return models.Post{}, err
+	}
+
+	query = `INSERT INTO post_revisions (post, version, message, editor) VALUES ($1, $2, $3, NULLIF($4, '')::citext)`
+	if _, err = tx.Exec(query, res.ID, res.Version, res.Message, editor); err != nil {
+		return models.Post{}, err
+	}
+	return res, tx.Commit()
+}
+
+// GetPostRevisions lists the revisions of a post, oldest first. A post that
+// was never edited has its current message as the only revision.
+func (p Postgres) GetPostRevisions(id uint64) ([]models.Revision, error) {
+	query := `
+		SELECT version, message, COALESCE(editor, '') AS editor, created FROM post_revisions WHERE post = $1
+		UNION ALL
+		SELECT version, message, author, created FROM posts
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM post_revisions WHERE post = $1)
+		ORDER BY version`
+	revisions := make([]models.Revision, 0)
+	err := p.DB.Select(&revisions, query, id)
+	return revisions, err
 }
 
-func (p Postgres) GetThreadPostsFlat(id uint64, limit uint64, since uint64, desk bool) ([]models.Post, error) {
+func (p Postgres) GetThreadPostsFlat(id uint64, limit uint64, since uint64, desk bool, viewer string) ([]models.Post, error) {
 	res := make([]models.Post, 0)
 	query := `
 		SELECT p.id, p.author, p.created, p.forum, p.is_edited, p.message, p.parent, p.thread FROM posts p WHERE p.thread = $1
 	`
+	args := []interface{}{id}
+	if viewer != "" {
+		args = append(args, viewer)
+		query += hiddenAuthors("p.author", len(args))
+	}
 
 	if since != 0 {
 		if desk {
@@ -99,11 +146,11 @@ func (p Postgres) GetThreadPostsFlat(id uint64, limit uint64, since uint64, desk
 		query += fmt.Sprintf(` LIMIT %d`, limit)
 	}
 
-	err := p.DB.Select(&res, query, id)
+	err := p.DB.Select(&res, query, args...)
 	return res, err
 }
 
-func (p Postgres) GetThreadPostsTree(id uint64, limit uint64, since uint64, desk bool) ([]models.Post, error) {
+func (p Postgres) GetThreadPostsTree(id uint64, limit uint64, since uint64, desk bool, viewer string) ([]models.Post, error) {
 	posts := make([]models.Post, 0)
 
 	query := `
@@ -111,6 +158,11 @@ func (p Postgres) GetThreadPostsTree(id uint64, limit uint64, since uint64, desk
 		FROM posts p
 		WHERE p.thread = $1
 	`
+	args := []interface{}{id}
+	if viewer != "" {
+		args = append(args, viewer)
+		query += hiddenSubtrees("p.path", len(args))
+	}
 
 	if since != 0 {
 		if desk {
@@ -130,11 +182,11 @@ func (p Postgres) GetThreadPostsTree(id uint64, limit uint64, since uint64, desk
 		query += fmt.Sprintf(` LIMIT %d`, limit)
 	}
 
-	err := p.DB.Select(&posts, query, id)
+	err := p.DB.Select(&posts, query, args...)
 	return posts, err
 }
 
-func (p Postgres) GetThreadPostsParentTree(id uint64, limit uint64, since uint64, desk bool) ([]models.Post, error) {
+func (p Postgres) GetThreadPostsParentTree(id uint64, limit uint64, since uint64, desk bool, viewer string) ([]models.Post, error) {
 	posts := make([]models.Post, 0)
 
 	query := `SELECT id, author, created, forum, is_edited, message, parent, thread FROM posts`
@@ -153,16 +205,37 @@ func (p Postgres) GetThreadPostsParentTree(id uint64, limit uint64, since uint64
 		}
 	}
 
+	args := []interface{}{id, limit}
+	if since != 0 {
+		args = []interface{}{id, since, limit}
+	}
+	// Roots are picked before filtering, so a hidden root still takes its
+	// slot in the page and since keeps pointing at the same tree.
+	if viewer != "" {
+		args = append(args, viewer)
+		query += hiddenSubtrees("posts.path", len(args))
+	}
+
 	if desk {
 		query += ` ORDER BY path[1] DESC, path`
 	} else {
 		query += ` ORDER BY path[1] ASC, path`
 	}
 
-	if since != 0 {
-		err := p.DB.Select(&posts, query, id, since, limit)
-		return posts, err
-	}
-	err := p.DB.Select(&posts, query, id, limit)
+	err := p.DB.Select(&posts, query, args...)
 	return posts, err
 }
+
+// hiddenAuthors filters out authors the viewer muted or blocked. Since anchors
+// are looked up in the unfiltered table, so a hidden post still works as a
+// pagination cursor.
+func hiddenAuthors(column string, param int) string {
+	return fmt.Sprintf(` AND %s NOT IN (SELECT target FROM user_relations WHERE nickname = $%d)`, column, param)
+}
+
+// hiddenSubtrees is hiddenAuthors for the tree sorts: a hidden post takes its
+// replies with it, so no reply is shown without its parent.
+func hiddenSubtrees(path string, param int) string {
+	return fmt.Sprintf(` AND NOT EXISTS (SELECT 1 FROM posts hidden WHERE hidden.id = ANY(%s)
+		AND hidden.author IN (SELECT target FROM user_relations WHERE nickname = $%d))`, path, param)
+}
@@ -5,18 +5,25 @@ import (
 	"strconv"
 	forumRepository "technopark_db_forum/internal/forum/repository"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
 	"technopark_db_forum/internal/posts/repository"
 	"technopark_db_forum/internal/thread/repository"
 	"technopark_db_forum/internal/users/repository"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/slug"
 )
 
 type PostUsecase interface {
 	CreatePosts(posts []models.Post, slugOrID string) ([]models.Post, error)
 	GetPostByID(id uint64) (models.Post, error)
-	GetPostByIDRelared(id uint64, related []string) (models.PostFull, error)
-	UpdatePost(post models.Post) (models.Post, error)
-	GetThreadPosts(slugOrID string, limit uint64, sort string, since uint64, desk bool) ([]models.Post, error)
+	GetPostByIDRelared(id uint64, related []string, viewer string) (models.PostFull, error)
+	UpdatePost(post models.Post, actor string, version uint64) (models.Post, error)
+	GetThreadPosts(slugOrID string, limit uint64, sort string, since uint64, desk bool, viewer string) ([]models.Post, error)
+	SplitPost(id uint64, split models.PostSplit, actor string) (models.Thread, error)
+
+	GetRevisions(id uint64, viewer string) ([]models.Revision, error)
+	DiffRevisions(id, from, to uint64, viewer string) (string, error)
+	RollbackRevision(id, version uint64, actor string) (models.Post, error)
 }
 
 type usecase struct {
@@ -24,6 +31,7 @@ type usecase struct {
 	userRepository   userRepository.UserRepository
 	threadRepository threadRepository.ThreadRepository
 	forumRepository  forumRepository.ForumRepository
+	policy           policy.Enforcer
 }
 
 func NewPostUsecase(postRepo postRepository.PostRepository, userRepo userRepository.UserRepository, threadRepo threadRepository.ThreadRepository, forumRepo forumRepository.ForumRepository) PostUsecase {
@@ -32,6 +40,7 @@ func NewPostUsecase(postRepo postRepository.PostRepository, userRepo userReposit
 		userRepository:   userRepo,
 		threadRepository: threadRepo,
 		forumRepository:  forumRepo,
+		policy:           policy.NewEnforcer(userRepo, forumRepo),
 	}
 }
 
@@ -50,6 +59,22 @@ func (u usecase) CreatePosts(posts []models.Post, slugOrID string) ([]models.Pos
 		}
 	}
 
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return nil, err
+	}
+	if forum.Archived {
+		return nil, e.ErrForumArchived
+	}
+	switch thread.Status {
+	case models.ThreadLocked:
+		return nil, e.ErrThreadLocked
+	case models.ThreadClosed:
+		return nil, e.ErrThreadClosed
+	}
+	checked := map[string]bool{}
+	// Replies to authors who blocked the replier are checked together below.
+	var blockers, repliers []string
 
 	for index := range posts {
 		posts[index].ThreadID = thread.ID
@@ -60,6 +85,20 @@ func (u usecase) CreatePosts(posts []models.Post, slugOrID string) ([]models.Pos
 			return nil, e.ErrNoAuthorPost
 		}
 
+		banned, err := u.forumRepository.IsBanned(thread.Forum, posts[index].Author)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, e.ErrBanned
+		}
+		if !checked[posts[index].Author] {
+			if err = u.policy.AuthorizeAccess(posts[index].Author, forum, policy.Post); err != nil {
+				return nil, err
+			}
+			checked[posts[index].Author] = true
+		}
+
 		if posts[index].Parent != 0 {
 			parent, err := u.postRepository.GetPostByID(posts[index].Parent)
 
@@ -73,10 +112,21 @@ func (u usecase) CreatePosts(posts []models.Post, slugOrID string) ([]models.Pos
 			if parent.Forum != thread.Forum {
 				return nil, e.ErrOtherThread
 			}
+
+			blockers = append(blockers, parent.Author)
+			repliers = append(repliers, posts[index].Author)
 		}
 
 	}
 
+	blocked, err := u.userRepository.IsBlocked(blockers, repliers)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, e.ErrBlocked
+	}
+
 	res, err := u.postRepository.CreatePosts(posts)
 	if err != nil {
 		return nil, err
@@ -85,21 +135,21 @@ func (u usecase) CreatePosts(posts []models.Post, slugOrID string) ([]models.Pos
 		return make([]models.Post, 0), nil
 	}
 
-	for index := range res {
-		_, err := u.forumRepository.CreateForumUser(res[index].Forum, res[index].Author)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	return res, nil
 }
 
-func (u usecase) GetPostByIDRelared(id uint64, related []string) (models.PostFull, error) {
+func (u usecase) GetPostByIDRelared(id uint64, related []string, viewer string) (models.PostFull, error) {
 	post, err := u.postRepository.GetPostByID(id)
 	if err != nil {
 		return models.PostFull{}, err
 	}
+	forum, err := u.forumRepository.GetForumBySlug(post.Forum)
+	if err != nil {
+		return models.PostFull{}, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return models.PostFull{}, err
+	}
 
 	var postFull models.PostFull
 	postFull.Post = &post
@@ -113,10 +163,6 @@ func (u usecase) GetPostByIDRelared(id uint64, related []string) (models.PostFul
 			}
 			postFull.Author = &user
 		case "forum":
-			forum, err := u.forumRepository.GetForumBySlug(post.Forum)
-			if err != nil {
-				return models.PostFull{}, err
-			}
 			postFull.Forum = &forum
 		case "thread":
 			thread, err := u.threadRepository.GetThreadByID(post.ThreadID)
@@ -138,15 +184,49 @@ func (u usecase) GetPostByID(id uint64) (models.Post, error) {
 	return post, nil
 }
 
-func (u usecase) UpdatePost(post models.Post) (models.Post, error) {
-	res, err := u.postRepository.UpdatePost(post)
+// UpdatePost edits a post message. The actor has to be its author or moderate
+// the forum; a missing actor is ErrActorRequired. An empty or identical
+// message leaves the post, and its is_edited flag, untouched.
+func (u usecase) UpdatePost(post models.Post, actor string, version uint64) (models.Post, error) {
+	current, err := u.postRepository.GetPostByID(post.ID)
+	if err != nil {
+		return models.Post{}, err
+	}
+	if version != 0 && current.Version != version {
+		return models.Post{}, e.ErrPreconditionFailed
+	}
+
+	forum, err := u.forumRepository.GetForumBySlug(current.Forum)
+	if err != nil {
+		return models.Post{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.EditPost, current.Author); err != nil {
+		return models.Post{}, err
+	}
+
+	if post.Message == "" || post.Message == current.Message {
+		return current, nil
+	}
+
+	thread, err := u.threadRepository.GetThreadByID(current.ThreadID)
+	if err != nil {
+		return models.Post{}, err
+	}
+	if thread.Status == models.ThreadClosed {
+		return models.Post{}, e.ErrThreadClosed
+	}
+
+	res, err := u.postRepository.UpdatePost(post, version, actor)
 	if err != nil {
+		if err == sql.ErrNoRows && version != 0 {
+			return models.Post{}, e.ErrPreconditionFailed
+		}
 		return models.Post{}, err
 	}
 	return res, nil
 }
 
-func (u usecase) GetThreadPosts(slugOrID string, limit uint64, sort string, since uint64, desk bool) ([]models.Post, error) {
+func (u usecase) GetThreadPosts(slugOrID string, limit uint64, sort string, since uint64, desk bool, viewer string) ([]models.Post, error) {
 	id, err := strconv.ParseUint(slugOrID, 10, 64)
 	var th models.Thread
 	if err != nil {
@@ -161,30 +241,88 @@ func (u usecase) GetThreadPosts(slugOrID string, limit uint64, sort string, sinc
 		}
 	}
 
+	forum, err := u.forumRepository.GetForumBySlug(th.Forum)
+	if err != nil {
+		return nil, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return nil, err
+	}
+
 	switch sort {
 	case "flat":
-		res, err := u.postRepository.GetThreadPostsFlat(th.ID, limit, since, desk)
+		res, err := u.postRepository.GetThreadPostsFlat(th.ID, limit, since, desk, viewer)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
 	case "tree":
-		res, err := u.postRepository.GetThreadPostsTree(th.ID, limit, since, desk)
+		res, err := u.postRepository.GetThreadPostsTree(th.ID, limit, since, desk, viewer)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
 	case "parent_tree":
-		res, err := u.postRepository.GetThreadPostsParentTree(th.ID, limit, since, desk)
+		res, err := u.postRepository.GetThreadPostsParentTree(th.ID, limit, since, desk, viewer)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
 	default:
-		res, err := u.postRepository.GetThreadPostsFlat(th.ID, limit, since, desk)
+		res, err := u.postRepository.GetThreadPostsFlat(th.ID, limit, since, desk, viewer)
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
 	}
 }
+
+// SplitPost moves a post and its replies into a new thread. The actor has to
+// moderate the forum of the post and the one the new thread goes to.
+func (u usecase) SplitPost(id uint64, split models.PostSplit, actor string) (models.Thread, error) {
+	if split.Title == "" {
+		return models.Thread{}, e.ErrInvalidSplit
+	}
+
+	post, err := u.postRepository.GetPostByID(id)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	source, err := u.forumRepository.GetForumBySlug(post.Forum)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	target := source
+	if split.Forum != "" {
+		target, err = u.forumRepository.GetForumBySlug(split.Forum)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return models.Thread{}, e.ErrForumNotFound
+			}
+			return models.Thread{}, err
+		}
+	}
+
+	if err = u.policy.Authorize(actor, source, policy.SplitThread, ""); err != nil {
+		return models.Thread{}, err
+	}
+	if err = u.policy.Authorize(actor, target, policy.SplitThread, ""); err != nil {
+		return models.Thread{}, err
+	}
+	if target.Archived || source.Archived {
+		return models.Thread{}, e.ErrForumArchived
+	}
+
+	thread := models.Thread{Title: split.Title, Forum: target.Slug}
+	for attempt := 1; ; attempt++ {
+		if thread.Slug, err = u.threadRepository.FreeSlug(slug.Make(split.Title, "thread")); err != nil {
+			return models.Thread{}, err
+		}
+
+		res, err := u.threadRepository.SplitThread(id, thread, actor)
+		if err == e.ErrDuplicate && attempt < slug.MaxAttempts {
+			continue
+		}
+		return res, err
+	}
+}
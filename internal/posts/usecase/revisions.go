@@ -0,0 +1,76 @@
+package usecase
+
+import (
+	"fmt"
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/internal/revision"
+)
+
+// readablePost finds a post the viewer may read.
+func (u usecase) readablePost(id uint64, viewer string) (models.Post, error) {
+	post, err := u.postRepository.GetPostByID(id)
+	if err != nil {
+		return models.Post{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(post.Forum)
+	if err != nil {
+		return models.Post{}, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return models.Post{}, err
+	}
+	return post, nil
+}
+
+// GetRevisions lists the edit history of a post, oldest first.
+func (u usecase) GetRevisions(id uint64, viewer string) ([]models.Revision, error) {
+	if _, err := u.readablePost(id, viewer); err != nil {
+		return nil, err
+	}
+	return u.postRepository.GetPostRevisions(id)
+}
+
+// DiffRevisions renders a unified diff between two revisions of a post, see
+// revision.Pick for the defaults.
+func (u usecase) DiffRevisions(id, from, to uint64, viewer string) (string, error) {
+	if _, err := u.readablePost(id, viewer); err != nil {
+		return "", err
+	}
+	revisions, err := u.postRepository.GetPostRevisions(id)
+	if err != nil {
+		return "", err
+	}
+	a, b, err := revision.Pick(revisions, from, to)
+	if err != nil {
+		return "", err
+	}
+	return revision.Diff(fmt.Sprintf("post %d", id), a, b), nil
+}
+
+// RollbackRevision restores the message of an earlier revision as a new one.
+// Moderators of the forum may do it.
+func (u usecase) RollbackRevision(id, version uint64, actor string) (models.Post, error) {
+	post, err := u.postRepository.GetPostByID(id)
+	if err != nil {
+		return models.Post{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(post.Forum)
+	if err != nil {
+		return models.Post{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.RollbackRevision, ""); err != nil {
+		return models.Post{}, err
+	}
+
+	revisions, err := u.postRepository.GetPostRevisions(id)
+	if err != nil {
+		return models.Post{}, err
+	}
+	rev, err := revision.Find(revisions, version)
+	if err != nil {
+		return models.Post{}, err
+	}
+
+	return u.UpdatePost(models.Post{ID: id, Message: rev.Message}, actor, 0)
+}
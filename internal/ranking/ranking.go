@@ -0,0 +1,77 @@
+// Package ranking holds the trending formulas. They are pure functions of a
+// thread's signals and the current time, so they can be swapped or tuned
+// without touching the job that stores the scores.
+package ranking
+
+import (
+	"math"
+	"technopark_db_forum/internal/models"
+	"time"
+)
+
+// VelocityWindow is the period ThreadSignals.RecentReplies is counted over.
+const VelocityWindow = 6 * time.Hour
+
+type Ranker interface {
+	Score(thread models.ThreadSignals, now time.Time) float64
+	// Decays tells whether the score drifts with time alone, in which case
+	// active threads are rescored even when nothing happened to them.
+	Decays() bool
+}
+
+// Rising blends the vote score with the reply rate over VelocityWindow and
+// lets the sum fall off with age, Hacker News style: (points) / (hours +
+// 2)^gravity. It is not the forum listing's sort=hot, which is a fixed
+// log-score key stored on the thread, see thread_hot in db/db.sql.
+type Rising struct {
+	Gravity     float64
+	ReplyWeight float64
+}
+
+func (r Rising) Score(thread models.ThreadSignals, now time.Time) float64 {
+	age := now.Sub(thread.Created).Hours()
+	if age < 0 {
+		age = 0
+	}
+	velocity := float64(thread.RecentReplies) / VelocityWindow.Hours()
+	points := float64(thread.Votes) + r.ReplyWeight*velocity
+	return points / math.Pow(age+2, r.Gravity)
+}
+
+func (r Rising) Decays() bool {
+	return true
+}
+
+// Wilson is the lower bound of the Wilson score interval of the share of
+// upvotes, so a few unanimous votes don't beat many mostly positive ones. Z
+// is the normal quantile of the confidence level, 1.96 for 95%.
+type Wilson struct {
+	Z float64
+}
+
+func (w Wilson) Score(thread models.ThreadSignals, now time.Time) float64 {
+	n := float64(thread.Upvotes + thread.Downvotes)
+	if n == 0 {
+		return 0
+	}
+	p := float64(thread.Upvotes) / n
+	z2 := w.Z * w.Z
+	return (p + z2/(2*n) - w.Z*math.Sqrt((p*(1-p)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+func (w Wilson) Decays() bool {
+	return false
+}
+
+const (
+	RankerRising = "rising"
+	RankerBest   = "best"
+)
+
+// Default is the set of rankers the server scores threads with.
+func Default() map[string]Ranker {
+	return map[string]Ranker{
+		RankerRising: Rising{Gravity: 1.8, ReplyWeight: 2},
+		RankerBest:   Wilson{Z: 1.96},
+	}
+}
@@ -0,0 +1,121 @@
+package ranking
+
+import (
+	"math"
+	"technopark_db_forum/internal/models"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRisingDecaysWithAge(t *testing.T) {
+	rising := Rising{Gravity: 1.8, ReplyWeight: 2}
+	fresh := rising.Score(models.ThreadSignals{Votes: 10, Created: now.Add(-time.Hour)}, now)
+	day := rising.Score(models.ThreadSignals{Votes: 10, Created: now.Add(-24 * time.Hour)}, now)
+	week := rising.Score(models.ThreadSignals{Votes: 10, Created: now.Add(-7 * 24 * time.Hour)}, now)
+
+	if !(fresh > day && day > week) {
+		t.Fatalf("score should fall with age: 1h %v, 1d %v, 7d %v", fresh, day, week)
+	}
+	if want := 10 / math.Pow(3, 1.8); math.Abs(fresh-want) > 1e-9 {
+		t.Fatalf("1h old thread: got %v, want %v", fresh, want)
+	}
+}
+
+func TestRisingFutureCreatedCountsAsNew(t *testing.T) {
+	rising := Rising{Gravity: 1.8}
+	future := rising.Score(models.ThreadSignals{Votes: 5, Created: now.Add(time.Hour)}, now)
+	current := rising.Score(models.ThreadSignals{Votes: 5, Created: now}, now)
+	if future != current {
+		t.Fatalf("clock skew: got %v, want %v", future, current)
+	}
+}
+
+func TestRisingReplyVelocity(t *testing.T) {
+	rising := Rising{Gravity: 1.8, ReplyWeight: 2}
+	created := now.Add(-2 * time.Hour)
+	quiet := rising.Score(models.ThreadSignals{Votes: 3, Created: created}, now)
+	busy := rising.Score(models.ThreadSignals{Votes: 3, RecentReplies: 60, Created: created}, now)
+
+	if busy <= quiet {
+		t.Fatalf("recent replies should lift the score: quiet %v, busy %v", quiet, busy)
+	}
+	// 60 replies over the 6h window are 10 an hour, worth 20 points.
+	if want := (3 + 2*10) / math.Pow(4, 1.8); math.Abs(busy-want) > 1e-9 {
+		t.Fatalf("busy thread: got %v, want %v", busy, want)
+	}
+
+	ignored := Rising{Gravity: 1.8}.Score(models.ThreadSignals{Votes: 3, RecentReplies: 60, Created: created}, now)
+	if ignored != quiet {
+		t.Fatalf("zero reply weight: got %v, want %v", ignored, quiet)
+	}
+}
+
+func TestWilsonNoVotes(t *testing.T) {
+	if got := (Wilson{Z: 1.96}).Score(models.ThreadSignals{}, now); got != 0 {
+		t.Fatalf("n=0: got %v, want 0", got)
+	}
+}
+
+func TestWilsonUnanimous(t *testing.T) {
+	w := Wilson{Z: 1.96}
+	up := w.Score(models.ThreadSignals{Upvotes: 10}, now)
+	down := w.Score(models.ThreadSignals{Downvotes: 10}, now)
+
+	if up <= 0 || up >= 1 {
+		t.Fatalf("10 upvotes: got %v, want a lower bound in (0, 1)", up)
+	}
+	if down != 0 {
+		t.Fatalf("10 downvotes: got %v, want 0", down)
+	}
+	if more := w.Score(models.ThreadSignals{Upvotes: 100}, now); more <= up {
+		t.Fatalf("more unanimous votes should be more certain: 10 %v, 100 %v", up, more)
+	}
+}
+
+func TestWilsonFewUnanimousLoseToManyMostlyPositive(t *testing.T) {
+	w := Wilson{Z: 1.96}
+	few := w.Score(models.ThreadSignals{Upvotes: 3}, now)
+	many := w.Score(models.ThreadSignals{Upvotes: 300, Downvotes: 30}, now)
+
+	if few >= many {
+		t.Fatalf("3/0 should rank below 300/30: %v >= %v", few, many)
+	}
+	if math.Abs(few-0.4385) > 1e-3 {
+		t.Fatalf("3/0: got %v, want about 0.4385", few)
+	}
+	if math.Abs(many-0.8732) > 1e-3 {
+		t.Fatalf("300/30: got %v, want about 0.8732", many)
+	}
+}
+
+func TestWilsonIgnoresTime(t *testing.T) {
+	w := Wilson{Z: 1.96}
+	signals := models.ThreadSignals{Upvotes: 7, Downvotes: 2, Created: now.Add(-30 * 24 * time.Hour)}
+	if a, b := w.Score(signals, now), w.Score(signals, now.Add(365*24*time.Hour)); a != b {
+		t.Fatalf("score changed with time: %v, %v", a, b)
+	}
+}
+
+func TestDecays(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranker Ranker
+		want   bool
+	}{
+		{"rising", Rising{Gravity: 1.8}, true},
+		{"wilson", Wilson{Z: 1.96}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.ranker.Decays(); got != tt.want {
+			t.Errorf("%s: Decays() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for name, ranker := range Default() {
+		if want := name == RankerRising; ranker.Decays() != want {
+			t.Errorf("Default()[%q].Decays() = %v, want %v", name, ranker.Decays(), want)
+		}
+	}
+}
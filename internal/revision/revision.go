@@ -0,0 +1,60 @@
+// Package revision picks and compares entries of a thread or post edit
+// history.
+package revision
+
+import (
+	"fmt"
+	"strings"
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/pkg/diff"
+	e "technopark_db_forum/pkg/errors"
+)
+
+// Find returns the revision with the given version, or ErrRevisionNotFound.
+func Find(revisions []models.Revision, version uint64) (models.Revision, error) {
+	for _, rev := range revisions {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return models.Revision{}, e.ErrRevisionNotFound
+}
+
+// Pick resolves the two versions to compare. A zero to is the latest
+// revision and a zero from the one before to, so by default the last edit
+// is shown.
+func Pick(revisions []models.Revision, from, to uint64) (models.Revision, models.Revision, error) {
+	if len(revisions) == 0 {
+		return models.Revision{}, models.Revision{}, e.ErrRevisionNotFound
+	}
+	if to == 0 {
+		to = revisions[len(revisions)-1].Version
+	}
+	if from == 0 && to > 1 {
+		from = to - 1
+	} else if from == 0 {
+		from = to
+	}
+
+	a, err := Find(revisions, from)
+	if err != nil {
+		return models.Revision{}, models.Revision{}, err
+	}
+	b, err := Find(revisions, to)
+	if err != nil {
+		return models.Revision{}, models.Revision{}, err
+	}
+	return a, b, nil
+}
+
+// Diff renders a unified diff of the title and message of two revisions of
+// name, e.g. "thread 42". It is empty when they are the same. Titles are one
+// line, so they are diffed without a missing newline marker.
+func Diff(name string, a, b models.Revision) string {
+	var sb strings.Builder
+	if a.Title != b.Title {
+		sb.WriteString(diff.Unified(fmt.Sprintf("%s v%d title", name, a.Version), fmt.Sprintf("%s v%d title", name, b.Version), a.Title+"\n", b.Title+"\n"))
+	}
+	sb.WriteString(diff.Unified(fmt.Sprintf("%s v%d", name, a.Version), fmt.Sprintf("%s v%d", name, b.Version), a.Message, b.Message))
+	return sb.String()
+}
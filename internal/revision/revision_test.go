@@ -0,0 +1,83 @@
+package revision
+
+import (
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+	"testing"
+)
+
+var history = []models.Revision{
+	{Version: 1, Title: "Hello", Message: "first\n"},
+	{Version: 2, Title: "Hello", Message: "second\n"},
+	{Version: 3, Title: "Hello!", Message: "second\n"},
+}
+
+func TestPick(t *testing.T) {
+	tests := []struct {
+		name         string
+		revisions    []models.Revision
+		from, to     uint64
+		wantA, wantB uint64
+		wantErr      error
+	}{
+		{name: "last edit by default", revisions: history, wantA: 2, wantB: 3},
+		{name: "to only", revisions: history, to: 2, wantA: 1, wantB: 2},
+		{name: "both", revisions: history, from: 1, to: 3, wantA: 1, wantB: 3},
+		{name: "single revision", revisions: history[:1], wantA: 1, wantB: 1},
+		{name: "unknown version", revisions: history, from: 7, to: 2, wantErr: e.ErrRevisionNotFound},
+		{name: "no history", wantErr: e.ErrRevisionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b, err := Pick(tt.revisions, tt.from, tt.to)
+			if err != tt.wantErr {
+				t.Fatalf("Pick() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (a.Version != tt.wantA || b.Version != tt.wantB) {
+				t.Errorf("Pick() = v%d, v%d, want v%d, v%d", a.Version, b.Version, tt.wantA, tt.wantB)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b models.Revision
+		want string
+	}{
+		{
+			name: "same content",
+			a:    history[1],
+			b:    history[1],
+			want: "",
+		},
+		{
+			name: "message only",
+			a:    history[0],
+			b:    history[1],
+			want: "--- post 1 v1\n+++ post 1 v2\n@@ -1 +1 @@\n-first\n+second\n",
+		},
+		{
+			name: "title only",
+			a:    history[1],
+			b:    history[2],
+			want: "--- post 1 v2 title\n+++ post 1 v3 title\n@@ -1 +1 @@\n-Hello\n+Hello!\n",
+		},
+		{
+			name: "trailing newline",
+			a:    models.Revision{Version: 1, Message: "x"},
+			b:    models.Revision{Version: 2, Message: "x\n"},
+			want: "--- post 1 v1\n+++ post 1 v2\n@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff("post 1", tt.a, tt.b); got != tt.want {
+				t.Errorf("Diff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package delivery
 
 import (
 	"net/http"
+	"technopark_db_forum/internal/models"
 	"technopark_db_forum/internal/service/usecase"
+	"technopark_db_forum/pkg/timeseries"
 
 	"github.com/labstack/echo/v4"
 )
@@ -10,6 +12,7 @@ import (
 type ServiceHandler interface {
 	GetStatus(c echo.Context) error
 	Clear(c echo.Context) error
+	GetStats(c echo.Context) error
 }
 
 type serviceHandler struct {
@@ -37,3 +40,27 @@ func (h serviceHandler) Clear(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, struct{}{})
 }
+
+func (h serviceHandler) GetStats(c echo.Context) error {
+	from, to, err := timeseries.Parse(c.QueryParam("from"), c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		bucket = timeseries.Day
+	}
+
+	buckets, err := h.serviceUsecase.GetActivity(models.ActivityOptions{
+		From:   from,
+		To:     to,
+		Bucket: bucket,
+	})
+	if err != nil {
+		if err == timeseries.ErrInvalidRange {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, buckets)
+}
@@ -59,6 +59,6 @@ func (p Postgres) GetStatus() (models.ServiceStatus, error) {
 }
 
 func (p Postgres) Clear() error {
-	_, err := p.DB.Exec(`DELETE FROM forums; DELETE FROM threads; DELETE FROM posts; DELETE FROM votes; DELETE FROM forum_users; DELETE FROM users;`)
+	_, err := p.DB.Exec(`DELETE FROM forums; DELETE FROM threads; DELETE FROM posts; DELETE FROM votes; DELETE FROM forum_users; DELETE FROM audit_log; DELETE FROM idempotency_keys; DELETE FROM users;`)
 	return err
 }
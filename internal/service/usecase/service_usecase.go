@@ -1,22 +1,28 @@
 package usecase
 
 import (
+	forumRepository "technopark_db_forum/internal/forum/repository"
 	"technopark_db_forum/internal/models"
 	serviceRepository "technopark_db_forum/internal/service/repository"
+	"technopark_db_forum/pkg/timeseries"
+	"time"
 )
 
 type ServiceUsecase interface {
 	GetStatus() (models.ServiceStatus, error)
 	Clear() error
+	GetActivity(options models.ActivityOptions) ([]models.ActivityBucket, error)
 }
 
 type usecase struct {
 	serviceRepository serviceRepository.ServiceRepository
+	forumRepository   forumRepository.ForumRepository
 }
 
-func NewServiceUsecase(serviceRepo serviceRepository.ServiceRepository) ServiceUsecase {
+func NewServiceUsecase(serviceRepo serviceRepository.ServiceRepository, forumRepo forumRepository.ForumRepository) ServiceUsecase {
 	return &usecase{
 		serviceRepository: serviceRepo,
+		forumRepository:   forumRepo,
 	}
 }
 
@@ -35,3 +41,13 @@ func (u usecase) Clear() error {
 	}
 	return nil
 }
+
+// GetActivity is the forum activity series summed over every forum.
+func (u usecase) GetActivity(options models.ActivityOptions) ([]models.ActivityBucket, error) {
+	var err error
+	options.From, options.To, err = timeseries.Window(options.From, options.To, options.Bucket, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return u.forumRepository.GetActivity("", options)
+}
@@ -6,14 +6,24 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/ranking"
 	"technopark_db_forum/internal/thread/usecase"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/etag"
 	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// HeaderNextCursor carries the cursor of the next page of forum threads.
+const HeaderNextCursor = "X-Next-Cursor"
+
+const pollRules = "a poll needs a question, 2 to 20 distinct options and, if set, a closes_at in the future"
+
+const tagRules = "tags are letters, digits and _.+#- starting with a letter or digit, at most 32 characters and 10 per thread"
+
 type ThreadHandler struct {
 	threadUsecase usecase.ThreadUsecase
 }
@@ -42,6 +52,18 @@ func (h ThreadHandler) CreateThread(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread author by nickname: %s", thread.Author))
 		} else if err == e.ErrThreadNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread forum by slug: %s", thread.Forum))
+		} else if err == e.ErrBanned {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is banned from forum %s", thread.Author, thread.Forum))
+		} else if err == e.ErrForumArchived {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is archived", thread.Forum))
+		} else if err == e.ErrMembersOnly || err == e.ErrPostingRestricted {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s can't create threads in forum %s", thread.Author, thread.Forum))
+		} else if err == e.ErrInvalidTag {
+			return echo.NewHTTPError(http.StatusBadRequest, tagRules)
+		} else if err == e.ErrInvalidPoll {
+			return echo.NewHTTPError(http.StatusBadRequest, pollRules)
+		} else if err == e.ErrInvalidSlug {
+			return echo.NewHTTPError(http.StatusBadRequest, "slug must be 1 to 128 ASCII letters, digits, '-' and '_', and not all digits")
 		}
 		return err
 	}
@@ -71,11 +93,34 @@ func (h ThreadHandler) GetThreadMsgs(c echo.Context) error {
 		threadOptions.Desc = false
 	}
 	threadOptions.Desc = desk
+	threadOptions.Viewer = c.QueryParam("viewer")
+	threadOptions.Tag = c.QueryParam("tag")
 
-	thread, err := h.threadUsecase.GetThreadMsgsBySlug(slugOrID, since, threadOptions)
+	threadOptions.SortBy = c.QueryParam("sort")
+	switch threadOptions.SortBy {
+	case models.ThreadSortCreated, models.ThreadSortVotes, models.ThreadSortLastPost, models.ThreadSortReplies, models.ThreadSortHot:
+	case "":
+		threadOptions.SortBy = models.ThreadSortCreated
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be created, votes, last_post, replies or hot")
+	}
+
+	thread, next, err := h.threadUsecase.GetThreadMsgsBySlug(slugOrID, since, threadOptions, c.QueryParam("cursor"))
 	if err != nil {
+		if err == e.ErrInvalidCursor {
+			return echo.NewHTTPError(http.StatusBadRequest, "cursor is malformed or belongs to another sort order; since only works with sort=created")
+		}
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", slugOrID))
+		}
+		if err == e.ErrInvalidTag {
+			return echo.NewHTTPError(http.StatusBadRequest, tagRules)
+		}
 		return c.JSON(http.StatusNotFound, err)
 	}
+	if next != "" {
+		c.Response().Header().Set(HeaderNextCursor, next)
+	}
 	return c.JSON(http.StatusOK, thread)
 }
 
@@ -88,30 +133,53 @@ func (h ThreadHandler) UpdateThread(c echo.Context) error {
 	}
 	// thread.Slug = slugOrID
 
-	updatedThread, err := h.threadUsecase.UpdateThread(thread, slugOrID)
+	version, err := etag.IfMatch(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	updatedThread, err := h.threadUsecase.UpdateThread(thread, slugOrID, c.QueryParam("actor"), version)
 	if err != nil {
+		if errors.Is(err, e.ErrPreconditionFailed) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("Thread %s was modified, refetch it and retry", slugOrID))
+		}
 		if errors.Is(err, e.ErrNoRowsID) {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread with id: %d", thread.ID))
 		}
 		if errors.Is(err, e.ErrNoRowsSlug) {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread with slug: %s", thread.Slug))
 		}
+		if errors.Is(err, e.ErrThreadClosed) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is closed", slugOrID))
+		}
+		if errors.Is(err, e.ErrInvalidTag) {
+			return echo.NewHTTPError(http.StatusBadRequest, tagRules)
+		}
+		if errors.Is(err, e.ErrActorRequired) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		}
+		if errors.Is(err, e.ErrForbidden) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to edit thread %s", c.QueryParam("actor"), slugOrID))
+		}
 		return err
 	}
-	return c.JSON(http.StatusOK, updatedThread)
+	return etag.JSON(c, http.StatusOK, updatedThread.Version, updatedThread)
 }
 
 func (h ThreadHandler) GetThread(c echo.Context) error {
 	slugOrID := c.Param("slug_or_id")
 
-	thread, err := h.threadUsecase.GetThread(slugOrID)
+	thread, err := h.threadUsecase.GetThread(slugOrID, c.QueryParam("viewer"))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return c.JSON(http.StatusNotFound, err)
 		}
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, "The forum of this thread is visible to members only")
+		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
-	return c.JSON(http.StatusOK, thread)
+	return etag.JSON(c, http.StatusOK, thread.Version, thread)
 }
 
 func (h ThreadHandler) CreateVote(c echo.Context) error {
@@ -132,8 +200,18 @@ func (h ThreadHandler) CreateVote(c echo.Context) error {
 		}
 
 		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread with slug: %s", slugOrID))
+	} else if err == e.ErrInvalidVote {
+		return echo.NewHTTPError(http.StatusBadRequest, "voice must be -1, 1, or 0 to retract the vote")
 	} else if err == e.ErrUserNotFound {
 		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", vote.Nickname))
+	} else if err == e.ErrForumArchived {
+		return echo.NewHTTPError(http.StatusForbidden, "Forum of this thread is archived")
+	} else if err == e.ErrMembersOnly || err == e.ErrPostingRestricted {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s can't vote in this forum", vote.Nickname))
+	} else if err == e.ErrThreadLocked {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is locked", slugOrID))
+	} else if err == e.ErrThreadClosed {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is closed", slugOrID))
 	} else if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
@@ -160,3 +238,395 @@ func (h ThreadHandler) CreateVote(c echo.Context) error {
 	// }
 	// return c.JSON(http.StatusOK, updatedThread)
 }
+
+func (h ThreadHandler) SetThreadState(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	var state models.ThreadState
+	if err := c.Bind(&state); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	thread, err := h.threadUsecase.SetThreadState(slugOrID, state, c.QueryParam("actor"))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread by slug or id: %s", slugOrID))
+		case e.ErrInvalidState:
+			return echo.NewHTTPError(http.StatusBadRequest, "status must be open, locked or closed; pass at least one of status, pinned, announcement")
+		case e.ErrActorRequired:
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		case e.ErrForbidden:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to change the state of thread %s", c.QueryParam("actor"), slugOrID))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, thread)
+}
+
+// tags?forum=&viewer=&limit=
+func (h ThreadHandler) GetTags(c echo.Context) error {
+	forum := c.QueryParam("forum")
+	limit, _ := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
+
+	tags, err := h.threadUsecase.GetTags(forum, c.QueryParam("viewer"), limit)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum by slug: %s", forum))
+		case e.ErrMembersOnly:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", forum))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, tags)
+}
+
+// forum/slug/tags/rename?actor=
+func (h ThreadHandler) RenameTag(c echo.Context) error {
+	var rename models.TagRename
+	if err := c.Bind(&rename); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	return h.mergeTags(c, models.TagMerge{From: []string{rename.From}, To: rename.To})
+}
+
+// forum/slug/tags/merge?actor=
+func (h ThreadHandler) MergeTags(c echo.Context) error {
+	var merge models.TagMerge
+	if err := c.Bind(&merge); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+	return h.mergeTags(c, merge)
+}
+
+func (h ThreadHandler) mergeTags(c echo.Context, merge models.TagMerge) error {
+	slug := c.Param("slug")
+	actor := c.QueryParam("actor")
+
+	tags, err := h.threadUsecase.MergeTags(slug, merge, actor)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum by slug: %s", slug))
+		case e.ErrInvalidTag:
+			return echo.NewHTTPError(http.StatusBadRequest, tagRules)
+		case e.ErrActorRequired:
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		case e.ErrForbidden:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to manage tags of forum %s", actor, slug))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, tags)
+}
+
+// threads/trending?forum=&ranker=&window=&limit=&viewer=
+func (h ThreadHandler) GetTrending(c echo.Context) error {
+	options := models.TrendingOptions{
+		Forum:  c.QueryParam("forum"),
+		Ranker: c.QueryParam("ranker"),
+		Window: 24 * time.Hour,
+		Viewer: c.QueryParam("viewer"),
+	}
+	if options.Ranker == "" {
+		options.Ranker = ranking.RankerRising
+	}
+	if window := c.QueryParam("window"); window != "" {
+		var err error
+		if options.Window, err = parseWindow(window); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "window must be a duration such as 6h or 7d")
+		}
+	}
+	limit, err := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit == 0 {
+		limit = 20
+	}
+	options.Limit = limit
+
+	threads, err := h.threadUsecase.GetTrending(options)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum by slug: %s", options.Forum))
+		case e.ErrMembersOnly:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Forum %s is visible to members only", options.Forum))
+		case e.ErrInvalidRanking:
+			return echo.NewHTTPError(http.StatusBadRequest, "ranker must be rising or best and window at most 7d")
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, threads)
+}
+
+// parseWindow reads a Go duration, with days ("7d") on top.
+func parseWindow(window string) (time.Duration, error) {
+	if days := strings.TrimSuffix(window, "d"); days != window {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(window)
+}
+
+// moderationError maps the errors shared by the thread moderation endpoints.
+func moderationError(c echo.Context, err error, slugOrID string) error {
+	switch err {
+	case sql.ErrNoRows:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread by slug or id: %s", slugOrID))
+	case e.ErrActorRequired:
+		return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+	case e.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to moderate thread %s", c.QueryParam("actor"), slugOrID))
+	case e.ErrForumArchived:
+		return echo.NewHTTPError(http.StatusForbidden, "Target forum is archived")
+	default:
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+}
+
+func (h ThreadHandler) DeleteThread(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	if err := h.threadUsecase.DeleteThread(slugOrID, c.QueryParam("actor")); err != nil {
+		return moderationError(c, err, slugOrID)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h ThreadHandler) MoveThread(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	var move models.ThreadMove
+	if err := c.Bind(&move); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	thread, err := h.threadUsecase.MoveThread(slugOrID, move.Forum, c.QueryParam("actor"))
+	if err != nil {
+		if err == e.ErrForumNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum by slug: %s", move.Forum))
+		}
+		return moderationError(c, err, slugOrID)
+	}
+	return c.JSON(http.StatusOK, thread)
+}
+
+func (h ThreadHandler) MergeThread(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	var merge models.ThreadMerge
+	if err := c.Bind(&merge); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	res, err := h.threadUsecase.MergeThread(slugOrID, merge.Into, c.QueryParam("actor"))
+	if err != nil {
+		switch err {
+		case e.ErrThreadNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread by slug or id: %s", merge.Into))
+		case e.ErrInvalidMerge:
+			return echo.NewHTTPError(http.StatusBadRequest, "A thread can't be merged into itself")
+		case e.ErrMergePoll:
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("Thread %s has a poll and can't be merged", slugOrID))
+		case e.ErrThreadLocked:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is locked", merge.Into))
+		case e.ErrThreadClosed:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is closed", merge.Into))
+		}
+		return moderationError(c, err, slugOrID)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// revisionError maps the errors of the revision endpoints.
+func revisionError(c echo.Context, err error, slugOrID string) error {
+	switch err {
+	case e.ErrRevisionNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find that revision of thread %s", slugOrID))
+	case e.ErrMembersOnly:
+		return echo.NewHTTPError(http.StatusForbidden, "The forum of this thread is visible to members only")
+	case e.ErrThreadClosed:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is closed", slugOrID))
+	default:
+		return moderationError(c, err, slugOrID)
+	}
+}
+
+// thread/slug_or_id/revisions?viewer=
+func (h ThreadHandler) GetRevisions(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	revisions, err := h.threadUsecase.GetRevisions(slugOrID, c.QueryParam("viewer"))
+	if err != nil {
+		return revisionError(c, err, slugOrID)
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// thread/slug_or_id/revisions/diff?from=&to=&viewer=
+func (h ThreadHandler) DiffRevisions(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	var from, to uint64
+	var err error
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "from must be a revision version")
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "to must be a revision version")
+		}
+	}
+
+	diff, err := h.threadUsecase.DiffRevisions(slugOrID, from, to, c.QueryParam("viewer"))
+	if err != nil {
+		return revisionError(c, err, slugOrID)
+	}
+	return c.String(http.StatusOK, diff)
+}
+
+// thread/slug_or_id/revisions/version/rollback?actor=
+func (h ThreadHandler) RollbackRevision(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+	version, err := strconv.ParseUint(c.Param("version"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	thread, err := h.threadUsecase.RollbackRevision(slugOrID, version, c.QueryParam("actor"))
+	if err != nil {
+		return revisionError(c, err, slugOrID)
+	}
+	return etag.JSON(c, http.StatusOK, thread.Version, thread)
+}
+
+// pollError maps the errors of the poll endpoints.
+func pollError(c echo.Context, err error, slugOrID, nickname string) error {
+	switch err {
+	case e.ErrPollNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Thread %s has no poll", slugOrID))
+	case e.ErrInvalidBallot:
+		return echo.NewHTTPError(http.StatusBadRequest, "a ballot picks options of this poll, exactly one unless it is multiple choice")
+	case e.ErrPollClosed:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("The poll of thread %s is closed", slugOrID))
+	default:
+		return voteError(c, err, slugOrID, nickname)
+	}
+}
+
+// voteError maps the errors of voting on a thread or its poll.
+func voteError(c echo.Context, err error, slugOrID, nickname string) error {
+	switch err {
+	case sql.ErrNoRows:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread by slug or id: %s", slugOrID))
+	case e.ErrUserNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+	case e.ErrForumArchived:
+		return echo.NewHTTPError(http.StatusForbidden, "Forum of this thread is archived")
+	case e.ErrMembersOnly, e.ErrPostingRestricted:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s can't vote in this forum", nickname))
+	case e.ErrThreadLocked:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is locked", slugOrID))
+	case e.ErrThreadClosed:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Thread %s is closed", slugOrID))
+	default:
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+}
+
+// thread/slug_or_id/poll?viewer=
+func (h ThreadHandler) GetPoll(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+	viewer := c.QueryParam("viewer")
+
+	poll, err := h.threadUsecase.GetPoll(slugOrID, viewer)
+	if err != nil {
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, "The forum of this thread is visible to members only")
+		}
+		return pollError(c, err, slugOrID, viewer)
+	}
+	return c.JSON(http.StatusOK, poll)
+}
+
+func (h ThreadHandler) CastBallot(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	var ballot models.Ballot
+	if err := c.Bind(&ballot); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	poll, err := h.threadUsecase.CastBallot(slugOrID, ballot)
+	if err != nil {
+		return pollError(c, err, slugOrID, ballot.Nickname)
+	}
+	return c.JSON(http.StatusOK, poll)
+}
+
+// thread/slug_or_id/vote?nickname=
+func (h ThreadHandler) RetractVote(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+	nickname := c.QueryParam("nickname")
+
+	state, err := h.threadUsecase.RetractVote(slugOrID, nickname)
+	if err != nil {
+		return voteError(c, err, slugOrID, nickname)
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// thread/slug_or_id/vote?nickname=
+func (h ThreadHandler) GetVote(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+	nickname := c.QueryParam("nickname")
+
+	state, err := h.threadUsecase.GetVote(slugOrID, nickname)
+	if err != nil {
+		if err == e.ErrMembersOnly {
+			return echo.NewHTTPError(http.StatusForbidden, "The forum of this thread is visible to members only")
+		}
+		return voteError(c, err, slugOrID, nickname)
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+// thread/slug_or_id/votes?limit=&since=&desc=&viewer=
+func (h ThreadHandler) GetVotes(c echo.Context) error {
+	slugOrID := c.Param("slug_or_id")
+
+	limit, err := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
+	if err != nil {
+		limit = 100
+	}
+	desc, err := strconv.ParseBool(c.QueryParam("desc"))
+	if err != nil {
+		desc = false
+	}
+
+	votes, err := h.threadUsecase.GetVotes(slugOrID, c.QueryParam("viewer"), models.VoteOptions{
+		Limit: limit,
+		Since: c.QueryParam("since"),
+		Desc:  desc,
+	})
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find thread by slug or id: %s", slugOrID))
+		case e.ErrMembersOnly:
+			return echo.NewHTTPError(http.StatusForbidden, "The forum of this thread is visible to members only")
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, votes)
+}
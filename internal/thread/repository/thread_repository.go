@@ -1,8 +1,14 @@
 package threadRepository
 
 import (
+	"database/sql"
+	"fmt"
+	"strconv"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/pkg/audit"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/slug"
 	"time"
 
 	"github.com/lib/pq"
@@ -14,14 +20,40 @@ import (
 type ThreadRepository interface {
 	CreateThread(thread models.Thread) (models.Thread, error)
 	GetThreadBySlug(slug string) (models.Thread, error)
+	FreeSlug(base string) (string, error)
 	GetThreadByID(id uint64) (models.Thread, error)
-	GetThreadMsgs(slugOrID string, since time.Time, options models.ThreadOptions) ([]models.Thread, error)
-	UpdateThread(thread models.Thread) (models.ThreadNoVotes, error)
+	GetThreadMsgs(slugOrID string, since time.Time, options models.ThreadOptions) ([]models.Thread, []models.Thread, error)
+	UpdateThread(thread models.Thread, version uint64, editor string) (models.ThreadNoVotes, error)
+	GetThreadRevisions(id uint64) ([]models.Revision, error)
 
 	VoteBySlug(slug string, v models.Vote) (models.Thread, error)
 	VoteByID(id uint64, v models.Vote) (models.Thread, error)
+	RetractVote(id uint64, nickname string) (models.VoteState, error)
+	GetVote(id uint64, nickname string) (models.VoteState, error)
+	GetVotes(id uint64, options models.VoteOptions) ([]models.Vote, error)
+
+	SetThreadState(thread models.Thread, actor string) (models.Thread, error)
+
+	GetThreadTags(ids []uint64) (map[uint64][]string, error)
+	GetTags(forum string, limit uint64) ([]models.TagCount, error)
+	MergeTags(forum string, merge models.TagMerge, actor string) error
+
+	Rescore(now, horizon, stale, velocity time.Time, limit uint64, score func([]models.ThreadSignals) []models.ThreadScore) (int, error)
+	GetTrending(options models.TrendingOptions, since time.Time) ([]models.TrendingThread, error)
+
+	DeleteThread(thread models.Thread, actor string) error
+	MoveThread(thread models.Thread, forum, actor string) (models.Thread, error)
+	MergeThread(from, into models.Thread, actor string) (models.ThreadMergeResult, error)
+	SplitThread(post uint64, thread models.Thread, actor string) (models.Thread, error)
+
+	GetPoll(thread uint64, viewer string) (models.Poll, error)
+	CastBallot(poll uint64, ballot models.Ballot) error
 }
 
+// threadColumns is the column list every query returning a models.Thread selects.
+const threadColumns = `threads.id, COALESCE(threads.slug, '') AS slug, threads.author, threads.forum, threads.title, threads.message, threads.votes,
+	threads.created, threads.version, threads.status, threads.pinned, threads.announcement, threads.replies, threads.last_post_at, threads.hot`
+
 type Postgres struct {
 	DB *sqlx.DB
 }
@@ -38,11 +70,50 @@ func NewPostgres(url string) (*Postgres, error) {
 	return &Postgres{DB: db}, nil
 }
 
+// CreateThread inserts a thread with its tags and, when set, its poll.
 func (p Postgres) CreateThread(thread models.Thread) (models.Thread, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Thread{}, err
+	}
+	defer tx.Rollback()
+
 	var res models.Thread
-	query := `INSERT INTO threads (slug, author, forum, title, message, created) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, slug, author, forum, title, message, votes, created`
-	err := p.DB.QueryRowx(query, thread.Slug, thread.Author, thread.Forum, thread.Title, thread.Message, thread.Created).Scan(&res.ID, &res.Slug, &res.Author, &res.Forum, &res.Title, &res.Message, &res.Votes, &res.Created)
-	return res, err
+	query := `WITH inserted AS (
+			INSERT INTO threads (slug, author, forum, title, message, created) VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6) RETURNING ` + threadColumns + `
+		), tags AS (
+			INSERT INTO thread_tags (thread, tag, forum, created)
+			SELECT inserted.id, tag, inserted.forum, inserted.created FROM inserted, unnest($7::citext[]) tag
+		)
+		SELECT * FROM inserted`
+	err = tx.QueryRowx(query, thread.Slug, thread.Author, thread.Forum, thread.Title, thread.Message, thread.Created, pq.Array(thread.Tags)).StructScan(&res)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23505" {
+			return models.Thread{}, e.ErrDuplicate
+		}
+		return models.Thread{}, err
+	}
+	res.Tags = thread.Tags
+
+	if thread.Poll != nil {
+		poll := *thread.Poll
+		query = `INSERT INTO polls (thread, question, multiple, anonymous, closes_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+		if err = tx.QueryRow(query, res.ID, poll.Question, poll.Multiple, poll.Anonymous, poll.ClosesAt).Scan(&poll.ID); err != nil {
+			return models.Thread{}, err
+		}
+
+		poll.Options = make([]models.PollOption, len(thread.Poll.Options))
+		query = `INSERT INTO poll_options (poll, position, text) VALUES ($1, $2, $3) RETURNING id`
+		for i, option := range thread.Poll.Options {
+			poll.Options[i] = models.PollOption{Text: option.Text}
+			if err = tx.QueryRow(query, poll.ID, i, option.Text).Scan(&poll.Options[i].ID); err != nil {
+				return models.Thread{}, err
+			}
+		}
+		res.Poll = &poll
+	}
+	return res, tx.Commit()
 }
 
 func (p Postgres) InsertThread(thread models.Thread) error {
@@ -52,55 +123,172 @@ func (p Postgres) InsertThread(thread models.Thread) error {
 }
 
 func (p Postgres) GetThreadBySlug(slug string) (models.Thread, error) {
-	query := `SELECT id, slug, author, forum, title, message, votes, created FROM threads WHERE slug = $1`
+	query := `SELECT ` + threadColumns + ` FROM threads WHERE slug = $1`
 	thread := models.Thread{}
 	err := p.DB.Get(&thread, query, slug)
 	return thread, err
 }
 
 func (p Postgres) GetThreadByID(id uint64) (models.Thread, error) {
-	query := `SELECT id, slug, author, forum, title, message, votes, created FROM threads WHERE id = $1`
+	query := `SELECT ` + threadColumns + ` FROM threads WHERE id = $1`
 	thread := models.Thread{}
 	err := p.DB.Get(&thread, query, id)
 	return thread, err
 }
 
-func (p Postgres) GetThreadMsgs(slugOrID string, since time.Time, options models.ThreadOptions) ([]models.Thread, error) {
-	query := `SELECT threads.id, threads.slug, threads.author, threads.forum, threads.title, threads.message, threads.votes, threads.created FROM threads WHERE threads.forum = $1`
-	isTime := false
+// threadSortColumns maps ThreadOptions.SortBy to the column a listing is
+// ordered by; ties are broken by id.
+var threadSortColumns = map[string]string{
+	models.ThreadSortCreated:  `threads.created`,
+	models.ThreadSortVotes:    `threads.votes`,
+	models.ThreadSortLastPost: `threads.last_post_at`,
+	models.ThreadSortReplies:  `threads.replies`,
+	models.ThreadSortHot:      `threads.hot`,
+}
+
+// GetThreadMsgs lists the threads of a forum. The first page (no since or
+// cursor) of the unfiltered listing starts with the announcements and the
+// threads pinned in this forum, which are then left out of the regular order.
+// With a tag the listing is driven by the thread_tags index instead. A zero
+// limit lists every thread.
+func (p Postgres) GetThreadMsgs(slugOrID string, since time.Time, options models.ThreadOptions) ([]models.Thread, []models.Thread, error) {
+	hidden := ``
+	args := []interface{}{slugOrID}
+	if options.Viewer != "" {
+		args = append(args, options.Viewer)
+		hidden = fmt.Sprintf(` AND threads.author NOT IN (SELECT target FROM user_relations WHERE nickname = $%d)`, len(args))
+	}
+
+	// The pinned threads and announcements count against the limit.
+	limit := options.Limit
+	pinned := make([]models.Thread, 0)
+	if since == (time.Time{}) && options.Cursor == nil && options.Tag == "" {
+		pinnedArgs := append(append([]interface{}{}, args...), options.Viewer)
+		query := `SELECT ` + threadColumns + ` FROM threads
+			WHERE ((threads.forum = $1 AND pinned) OR (announcement AND EXISTS (
+				SELECT 1 FROM forums WHERE forums.slug = threads.forum AND ` + policy.ReadableBy("forums", fmt.Sprintf("$%d", len(pinnedArgs))) + `)))` + hidden +
+			` ORDER BY announcement DESC, created DESC, id DESC`
+		if limit != 0 {
+			pinnedArgs = append(pinnedArgs, limit)
+			query += fmt.Sprintf(` LIMIT $%d`, len(pinnedArgs))
+		}
+		if err := p.DB.Select(&pinned, query, pinnedArgs...); err != nil {
+			return nil, nil, err
+		}
+		if limit != 0 {
+			limit -= uint64(len(pinned))
+			if limit == 0 {
+				return pinned, make([]models.Thread, 0), nil
+			}
+		}
+	}
+
+	key, ok := threadSortColumns[options.SortBy]
+	if !ok {
+		key = threadSortColumns[models.ThreadSortCreated]
+	}
+	id := `threads.id`
+
+	query := `SELECT ` + threadColumns + ` FROM threads`
+	if options.Tag != "" {
+		args = append(args, options.Tag)
+		query += fmt.Sprintf(` JOIN thread_tags ON thread_tags.thread = threads.id AND thread_tags.forum = $1 AND thread_tags.tag = $%d`, len(args))
+		query += ` WHERE threads.forum = $1` + hidden
+		if key == threadSortColumns[models.ThreadSortCreated] {
+			key, id = `thread_tags.created`, `thread_tags.thread`
+		}
+	} else {
+		query += ` WHERE threads.forum = $1 AND NOT pinned AND NOT announcement` + hidden
+	}
+
 	if since != (time.Time{}) {
-		isTime = true
+		args = append(args, since)
+		if options.Desc {
+			query += fmt.Sprintf(` AND %s <= $%d`, key, len(args))
+		} else {
+			query += fmt.Sprintf(` AND %s >= $%d`, key, len(args))
+		}
+	}
+	if options.Cursor != nil && !options.Cursor.Start {
+		var value interface{}
+		switch options.SortBy {
+		case models.ThreadSortVotes, models.ThreadSortReplies:
+			value = options.Cursor.Count
+		case models.ThreadSortHot:
+			value = options.Cursor.Score
+		default:
+			value = options.Cursor.At
+		}
+		args = append(args, value, options.Cursor.ID)
 		if options.Desc {
-			query += ` AND created <= $2`
+			query += fmt.Sprintf(` AND (%s, %s) < ($%d, $%d)`, key, id, len(args)-1, len(args))
 		} else {
-			query += ` AND created >= $2`
+			query += fmt.Sprintf(` AND (%s, %s) > ($%d, $%d)`, key, id, len(args)-1, len(args))
 		}
 	}
-	query += ` ORDER BY created`
+
+	order := ``
 	if options.Desc {
-		query += ` DESC`
+		order = ` DESC`
 	}
-	if isTime {
-		query += ` LIMIT $3`
-	} else {
-		query += ` LIMIT $2`
+	query += ` ORDER BY ` + key + order + `, ` + id + order
+	if limit != 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
 	}
 
-	threads := make([]models.Thread, 0)
-	var err error
-	if isTime {
-		err = p.DB.Select(&threads, query, slugOrID, since, options.Limit)
-	} else {
-		err = p.DB.Select(&threads, query, slugOrID, options.Limit)
+	regular := make([]models.Thread, 0)
+	if err := p.DB.Select(&regular, query, args...); err != nil {
+		return nil, nil, err
 	}
-	return threads, err
+	return pinned, regular, nil
 }
 
-func (p Postgres) UpdateThread(thread models.Thread) (models.ThreadNoVotes, error) {
+// UpdateThread overwrites title and message, and the tags unless they are
+// nil, and records the new revision. A non-zero version makes the update
+// conditional, see users UpdateUser.
+func (p Postgres) UpdateThread(thread models.Thread, version uint64, editor string) (models.ThreadNoVotes, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	defer tx.Rollback()
+
+	// The first edit also saves the content it replaces.
+	query := `INSERT INTO thread_revisions (thread, version, title, message, editor, created)
+		SELECT id, version, title, message, CASE WHEN version = 1 THEN author END, CASE WHEN version = 1 THEN created ELSE NOW() END
+		FROM threads WHERE id = $1
+		ON CONFLICT DO NOTHING`
+	if _, err = tx.Exec(query, thread.ID); err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+
 	var res models.ThreadNoVotes
-	query := `UPDATE threads SET title = $1, message = $2 WHERE slug = $3 RETURNING id, slug, author, forum, title, message, created`
-	err := p.DB.QueryRowx(query, thread.Title, thread.Message, thread.Slug).Scan(&res.ID, &res.Slug, &res.Author, &res.Forum, &res.Title, &res.Message, &res.Created)
-	return res, err
+	query = `UPDATE threads SET title = $1, message = $2, version = version + 1
+		WHERE id = $3 AND ($4::bigint = 0 OR version = $4)
+		RETURNING id, COALESCE(slug, ''), author, forum, title, message, created, version`
+	err = tx.QueryRowx(query, thread.Title, thread.Message, thread.ID, version).Scan(&res.ID, &res.Slug, &res.Author, &res.Forum, &res.Title, &res.Message, &res.Created, &res.Version)
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+
+	query = `INSERT INTO thread_revisions (thread, version, title, message, editor) VALUES ($1, $2, $3, $4, NULLIF($5, '')::citext)`
+	if _, err = tx.Exec(query, res.ID, res.Version, res.Title, res.Message, editor); err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+
+	if thread.Tags != nil {
+		if _, err = tx.Exec(`DELETE FROM thread_tags WHERE thread = $1`, res.ID); err != nil {
+			return models.ThreadNoVotes{}, err
+		}
+		query = `INSERT INTO thread_tags (thread, tag, forum, created)
+			SELECT threads.id, tag, threads.forum, threads.created FROM threads, unnest($2::citext[]) tag WHERE threads.id = $1`
+		if _, err = tx.Exec(query, res.ID, pq.Array(thread.Tags)); err != nil {
+			return models.ThreadNoVotes{}, err
+		}
+		res.Tags = thread.Tags
+	}
+	return res, tx.Commit()
 }
 
 func (p Postgres) VoteBySlug(slug string, v models.Vote) (models.Thread, error) {
@@ -111,7 +299,7 @@ func (p Postgres) VoteBySlug(slug string, v models.Vote) (models.Thread, error)
 	err := p.DB.Get(
 		&thread,
 		`
-			SELECT id, author, created, forum, message, slug, title, votes
+			SELECT `+threadColumns+`
 			FROM threads
 			WHERE slug = $1
 		`,
@@ -143,7 +331,7 @@ func (p Postgres) VoteBySlug(slug string, v models.Vote) (models.Thread, error)
 	err = p.DB.Get(
 		&thread,
 		`
-			SELECT id, author, created, forum, message, slug, title, votes
+			SELECT `+threadColumns+`
 			FROM threads
 			WHERE slug = $1
 		`,
@@ -164,7 +352,7 @@ func (p *Postgres) VoteByID(id uint64, v models.Vote) (models.Thread, error) {
 	err := p.DB.Get(
 		&thread,
 		`
-			SELECT id, author, created, forum, message, slug, title, votes
+			SELECT `+threadColumns+`
 			FROM threads
 			WHERE id = $1
 		`,
@@ -196,7 +384,7 @@ func (p *Postgres) VoteByID(id uint64, v models.Vote) (models.Thread, error) {
 	err = p.DB.Get(
 		&thread,
 		`
-			SELECT id, author, created, forum, message, slug, title, votes
+			SELECT `+threadColumns+`
 			FROM threads
 			WHERE id = $1
 		`,
@@ -205,6 +393,550 @@ func (p *Postgres) VoteByID(id uint64, v models.Vote) (models.Thread, error) {
 	if err != nil {
 		return thread, err
 	}
+	thread.Voice = &v.VoiceValue
 
 	return thread, nil
 }
+
+// RetractVote deletes the user's vote, if any, and returns the score after.
+func (p Postgres) RetractVote(id uint64, nickname string) (models.VoteState, error) {
+	if _, err := p.DB.Exec(`DELETE FROM votes WHERE thread = $1 AND nickname = $2`, id, nickname); err != nil {
+		return models.VoteState{}, err
+	}
+	return p.GetVote(id, nickname)
+}
+
+func (p Postgres) GetVote(id uint64, nickname string) (models.VoteState, error) {
+	state := models.VoteState{Nickname: nickname}
+	query := `SELECT threads.id, COALESCE(votes.voice, 0), threads.votes
+		FROM threads LEFT JOIN votes ON votes.thread = threads.id AND votes.nickname = $2
+		WHERE threads.id = $1`
+	err := p.DB.QueryRow(query, id, nickname).Scan(&state.Thread, &state.Voice, &state.Votes)
+	return state, err
+}
+
+// GetVotes lists the voters of a thread by nickname, starting after since.
+func (p Postgres) GetVotes(id uint64, options models.VoteOptions) ([]models.Vote, error) {
+	query := `SELECT nickname, voice, thread FROM votes WHERE thread = $1`
+	args := []interface{}{id}
+
+	cmp := ">"
+	order := ""
+	if options.Desc {
+		cmp = "<"
+		order = " DESC"
+	}
+	if options.Since != "" {
+		args = append(args, options.Since)
+		query += fmt.Sprintf(` AND nickname %s $%d::citext`, cmp, len(args))
+	}
+	query += ` ORDER BY nickname` + order
+	if options.Limit != 0 {
+		args = append(args, options.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	votes := make([]models.Vote, 0)
+	err := p.DB.Select(&votes, query, args...)
+	return votes, err
+}
+
+func (p *Postgres) SetThreadState(thread models.Thread, actor string) (models.Thread, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Thread{}, err
+	}
+	defer tx.Rollback()
+
+	var res models.Thread
+	query := `UPDATE threads SET status = $2, pinned = $3, announcement = $4 WHERE id = $1 RETURNING ` + threadColumns
+	err = tx.QueryRowx(query, thread.ID, thread.Status, thread.Pinned, thread.Announcement).StructScan(&res)
+	if err != nil {
+		return models.Thread{}, err
+	}
+
+	details := models.ThreadState{Status: res.Status, Pinned: &res.Pinned, Announcement: &res.Announcement}
+	if err = audit.Record(tx, actor, "thread.state", strconv.FormatUint(res.ID, 10), details); err != nil {
+		return models.Thread{}, err
+	}
+	return res, tx.Commit()
+}
+
+// GetThreadTags returns the tags of each of the threads, sorted.
+func (p *Postgres) GetThreadTags(ids []uint64) (map[uint64][]string, error) {
+	rows := []struct {
+		Thread uint64 `db:"thread"`
+		Tag    string `db:"tag"`
+	}{}
+	query := `SELECT thread, tag FROM thread_tags WHERE thread = ANY($1::bigint[]) ORDER BY thread, tag`
+	if err := p.DB.Select(&rows, query, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	tags := make(map[uint64][]string, len(ids))
+	for _, row := range rows {
+		tags[row.Thread] = append(tags[row.Thread], row.Tag)
+	}
+	return tags, nil
+}
+
+// GetTags counts tagged threads per tag, in one forum or, when forum is
+// empty, across the public forums.
+func (p *Postgres) GetTags(forum string, limit uint64) ([]models.TagCount, error) {
+	query := `SELECT tag, COUNT(*) AS threads FROM thread_tags`
+	args := []interface{}{}
+	if forum != "" {
+		args = append(args, forum)
+		query += ` WHERE forum = $1`
+	} else {
+		query += ` WHERE forum IN (SELECT slug FROM forums WHERE visibility = 'public')`
+	}
+	query += ` GROUP BY tag ORDER BY threads DESC, tag`
+	if limit != 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	tags := make([]models.TagCount, 0)
+	err := p.DB.Select(&tags, query, args...)
+	return tags, err
+}
+
+func (p *Postgres) MergeTags(forum string, merge models.TagMerge, actor string) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO thread_tags (thread, tag, forum, created)
+		SELECT thread, $3, forum, created FROM thread_tags WHERE forum = $1 AND tag = ANY($2::citext[])
+		ON CONFLICT DO NOTHING`
+	if _, err = tx.Exec(query, forum, pq.Array(merge.From), merge.To); err != nil {
+		return err
+	}
+	query = `DELETE FROM thread_tags WHERE forum = $1 AND tag = ANY($2::citext[]) AND tag <> $3`
+	if _, err = tx.Exec(query, forum, pq.Array(merge.From), merge.To); err != nil {
+		return err
+	}
+
+	if err = audit.Record(tx, actor, "tags.merge", forum, merge); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rescore takes up to limit threads that need new trending scores: the ones
+// voted or posted in since their last scoring and, unless horizon is zero,
+// the ones active after horizon whose scores predate stale. score turns their
+// signals into scores, which are saved in the same transaction that clears
+// the dirty flag, so a failed save leaves the threads to the next run. It
+// returns the number of threads claimed.
+func (p *Postgres) Rescore(now, horizon, stale, velocity time.Time, limit uint64, score func([]models.ThreadSignals) []models.ThreadScore) (int, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		WITH claimed AS (
+			UPDATE threads SET rank_dirty = FALSE, ranked_at = $1
+			WHERE id IN (
+				SELECT id FROM threads
+				WHERE rank_dirty OR ($2::timestamptz IS NOT NULL AND last_post_at >= $2 AND ranked_at < $3)
+				ORDER BY rank_dirty DESC, ranked_at
+				LIMIT $4
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, forum, votes, replies, created, last_post_at
+		)
+		SELECT claimed.*, COALESCE(v.upvotes, 0) AS upvotes, COALESCE(v.downvotes, 0) AS downvotes, r.recent_replies
+		FROM claimed
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE voice > 0) AS upvotes, COUNT(*) FILTER (WHERE voice < 0) AS downvotes
+			FROM votes WHERE votes.thread = claimed.id
+		) v ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS recent_replies FROM posts WHERE posts.thread = claimed.id AND posts.created >= $5
+		) r ON TRUE`
+
+	signals := make([]models.ThreadSignals, 0)
+	err = tx.Select(&signals, query, now, pq.NullTime{Time: horizon, Valid: !horizon.IsZero()}, stale, limit, velocity)
+	if err != nil {
+		return 0, err
+	}
+	if len(signals) == 0 {
+		return 0, nil
+	}
+
+	if err = saveScores(tx, score(signals)); err != nil {
+		return 0, err
+	}
+	return len(signals), tx.Commit()
+}
+
+func saveScores(tx *sqlx.Tx, scores []models.ThreadScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+
+	threads := make([]int64, len(scores))
+	rankers := make([]string, len(scores))
+	forums := make([]string, len(scores))
+	values := make([]float64, len(scores))
+	for i, score := range scores {
+		threads[i] = int64(score.Thread)
+		rankers[i] = score.Ranker
+		forums[i] = score.Forum
+		values[i] = score.Score
+	}
+
+	query := `
+		INSERT INTO thread_rankings (thread, ranker, forum, score)
+		SELECT * FROM unnest($1::bigint[], $2::varchar[], $3::citext[], $4::float8[])
+		ON CONFLICT (thread, ranker) DO UPDATE SET forum = excluded.forum, score = excluded.score`
+	_, err := tx.Exec(query, pq.Array(threads), pq.Array(rankers), pq.Array(forums), pq.Array(values))
+	return err
+}
+
+// GetTrending lists the best scored threads of one ranker that saw activity
+// after since.
+func (p *Postgres) GetTrending(options models.TrendingOptions, since time.Time) ([]models.TrendingThread, error) {
+	args := []interface{}{options.Ranker, since}
+	query := `SELECT ` + threadColumns + `, thread_rankings.score FROM thread_rankings
+		JOIN threads ON threads.id = thread_rankings.thread
+		WHERE thread_rankings.ranker = $1 AND threads.last_post_at >= $2`
+	if options.Forum != "" {
+		args = append(args, options.Forum)
+		query += fmt.Sprintf(` AND thread_rankings.forum = $%d`, len(args))
+	} else {
+		query += ` AND thread_rankings.forum IN (SELECT slug FROM forums WHERE visibility = 'public')`
+	}
+	if options.Viewer != "" {
+		args = append(args, options.Viewer)
+		query += fmt.Sprintf(` AND threads.author NOT IN (SELECT target FROM user_relations WHERE nickname = $%d)`, len(args))
+	}
+	args = append(args, options.Limit)
+	query += fmt.Sprintf(` ORDER BY thread_rankings.score DESC, thread_rankings.thread DESC LIMIT $%d`, len(args))
+
+	threads := make([]models.TrendingThread, 0)
+	err := p.DB.Select(&threads, query, args...)
+	return threads, err
+}
+
+// DeleteThread removes a thread with its posts, votes and tags.
+func (p *Postgres) DeleteThread(thread models.Thread, actor string) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM threads WHERE id = $1`, thread.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	details := struct {
+		Forum string `json:"forum"`
+		Slug  string `json:"slug,omitempty"`
+		Title string `json:"title"`
+	}{thread.Forum, thread.Slug, thread.Title}
+	if err = audit.Record(tx, actor, "thread.delete", strconv.FormatUint(thread.ID, 10), details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveThread puts a thread into another forum; the triggers move its posts,
+// tags and counters along.
+func (p *Postgres) MoveThread(thread models.Thread, forum, actor string) (models.Thread, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Thread{}, err
+	}
+	defer tx.Rollback()
+
+	var res models.Thread
+	query := `UPDATE threads SET forum = $2 WHERE id = $1 RETURNING ` + threadColumns
+	err = tx.QueryRowx(query, thread.ID, forum).StructScan(&res)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return models.Thread{}, e.ErrForumNotFound
+		}
+		return models.Thread{}, err
+	}
+
+	details := struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}{thread.Forum, res.Forum}
+	if err = audit.Record(tx, actor, "thread.move", strconv.FormatUint(res.ID, 10), details); err != nil {
+		return models.Thread{}, err
+	}
+	return res, tx.Commit()
+}
+
+// MergeThread moves every post of from into into and deletes from. Post ids
+// are global, so the path trees stay valid as they are; the roots of from
+// become roots of into, and so does its opening title and message, posted by
+// its author at its creation time. Tags are carried over, votes are not. A
+// thread with a poll is refused with ErrMergePoll, the poll would be lost, and
+// a locked or closed target with ErrThreadLocked or ErrThreadClosed.
+func (p *Postgres) MergeThread(from, into models.Thread, actor string) (models.ThreadMergeResult, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	defer tx.Rollback()
+
+	// Both threads are locked, in id order, so no post lands in from while
+	// its posts are moved.
+	var source, target models.Thread
+	query := `SELECT ` + threadColumns + ` FROM threads WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`
+	locked := make([]models.Thread, 0, 2)
+	if err = tx.Select(&locked, query, from.ID, into.ID); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	if len(locked) != 2 {
+		return models.ThreadMergeResult{}, sql.ErrNoRows
+	}
+	for _, thread := range locked {
+		if thread.ID == into.ID {
+			target = thread
+		} else {
+			source = thread
+		}
+	}
+
+	var hasPoll bool
+	if err = tx.Get(&hasPoll, `SELECT EXISTS(SELECT 1 FROM polls WHERE thread = $1)`, source.ID); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	if hasPoll {
+		return models.ThreadMergeResult{}, e.ErrMergePoll
+	}
+	// The target may have been locked or closed since the usecase looked.
+	switch target.Status {
+	case models.ThreadLocked:
+		return models.ThreadMergeResult{}, e.ErrThreadLocked
+	case models.ThreadClosed:
+		return models.ThreadMergeResult{}, e.ErrThreadClosed
+	}
+
+	res := models.ThreadMergeResult{}
+	if err = tx.Get(&res.DroppedVotes, `SELECT COUNT(*) FROM votes WHERE thread = $1`, source.ID); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+
+	if _, err = tx.Exec(`UPDATE posts SET thread = $2, forum = $3 WHERE thread = $1`, source.ID, target.ID, target.Forum); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	query = `INSERT INTO posts (author, created, forum, message, parent, thread) VALUES ($1, $2, $3, $4, 0, $5) RETURNING id`
+	opening := source.Title + "\n\n" + source.Message
+	if err = tx.Get(&res.OpeningPost, query, source.Author, source.Created, target.Forum, opening, target.ID); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	query = `INSERT INTO thread_tags (thread, tag, forum, created)
+		SELECT $2, tag, $3, $4 FROM thread_tags WHERE thread = $1
+		ON CONFLICT DO NOTHING`
+	if _, err = tx.Exec(query, source.ID, target.ID, target.Forum, target.Created); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	if _, err = tx.Exec(`DELETE FROM threads WHERE id = $1`, source.ID); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+
+	details := struct {
+		Into         string `json:"into"`
+		OpeningPost  uint64 `json:"opening_post"`
+		DroppedVotes int64  `json:"dropped_votes"`
+	}{strconv.FormatUint(target.ID, 10), res.OpeningPost, res.DroppedVotes}
+	if err = audit.Record(tx, actor, "thread.merge", strconv.FormatUint(source.ID, 10), details); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+
+	if err = tx.Get(&res.Thread, `SELECT `+threadColumns+` FROM threads WHERE id = $1`, target.ID); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	return res, tx.Commit()
+}
+
+// SplitThread opens thread, with the message and author of post, and moves
+// post with all of its replies into it. Their paths lose the prefix above
+// post, which becomes a root post. In the old thread the actor leaves a stub
+// pointing to the new one where post was.
+func (p *Postgres) SplitThread(post uint64, thread models.Thread, actor string) (models.Thread, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.Thread{}, err
+	}
+	defer tx.Rollback()
+
+	var root struct {
+		Thread  uint64        `db:"thread"`
+		Forum   string        `db:"forum"`
+		Parent  uint64        `db:"parent"`
+		Author  string        `db:"author"`
+		Message string        `db:"message"`
+		Created time.Time     `db:"created"`
+		Path    pq.Int64Array `db:"path"`
+	}
+	query := `SELECT thread, forum, parent, author, message, created, path FROM posts WHERE id = $1 FOR UPDATE`
+	if err = tx.Get(&root, query, post); err != nil {
+		return models.Thread{}, err
+	}
+
+	var res models.Thread
+	query = `INSERT INTO threads (slug, author, forum, title, message) VALUES (NULLIF($1, ''), $2, $3, $4, $5) RETURNING ` + threadColumns
+	if err = tx.QueryRowx(query, thread.Slug, root.Author, thread.Forum, thread.Title, root.Message).StructScan(&res); err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return models.Thread{}, e.ErrForumNotFound
+		}
+		if ok && pgErr.Code == "23505" {
+			return models.Thread{}, e.ErrDuplicate
+		}
+		return models.Thread{}, err
+	}
+
+	query = `INSERT INTO posts (author, created, forum, message, parent, thread) VALUES ($1, $2, $3, $4, $5, $6)`
+	stub := fmt.Sprintf("Moved to thread %d: %s", res.ID, res.Title)
+	if _, err = tx.Exec(query, actor, root.Created, root.Forum, stub, root.Parent, root.Thread); err != nil {
+		return models.Thread{}, err
+	}
+
+	query = `UPDATE posts SET thread = $1, forum = $2, path = path[$5:], parent = CASE WHEN id = $3 THEN 0 ELSE parent END
+		WHERE thread = $4 AND path[1:$5] = $6::bigint[]`
+	_, err = tx.Exec(query, res.ID, res.Forum, post, root.Thread, len(root.Path), root.Path)
+	if err != nil {
+		return models.Thread{}, err
+	}
+
+	details := struct {
+		From uint64 `json:"from"`
+		To   uint64 `json:"to"`
+	}{root.Thread, res.ID}
+	if err = audit.Record(tx, actor, "post.split", strconv.FormatUint(post, 10), details); err != nil {
+		return models.Thread{}, err
+	}
+
+	if err = tx.Get(&res, `SELECT `+threadColumns+` FROM threads WHERE id = $1`, res.ID); err != nil {
+		return models.Thread{}, err
+	}
+	return res, tx.Commit()
+}
+
+// FreeSlug returns base, a generated slug and so free of LIKE wildcards, or,
+// when it is taken, base with the lowest free numeric suffix. A concurrent
+// insert can still take it first, callers retry on ErrDuplicate.
+func (p *Postgres) FreeSlug(base string) (string, error) {
+	taken := make([]string, 0)
+	query := `SELECT slug FROM threads WHERE slug = $1 OR slug LIKE $1 || '-%'`
+	if err := p.DB.Select(&taken, query, base); err != nil {
+		return "", err
+	}
+	return slug.Next(base, taken), nil
+}
+
+// GetThreadRevisions lists the revisions of a thread, oldest first. A thread
+// that was never edited has its current content as the only revision.
+func (p Postgres) GetThreadRevisions(id uint64) ([]models.Revision, error) {
+	query := `
+		SELECT version, title, message, COALESCE(editor, '') AS editor, created FROM thread_revisions WHERE thread = $1
+		UNION ALL
+		SELECT version, title, message, author, created FROM threads
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM thread_revisions WHERE thread = $1)
+		ORDER BY version`
+	revisions := make([]models.Revision, 0)
+	err := p.DB.Select(&revisions, query, id)
+	return revisions, err
+}
+
+// GetPoll loads the poll of a thread with the votes per option, the voters of
+// each option unless the poll is anonymous, and the viewer's choice.
+func (p Postgres) GetPoll(thread uint64, viewer string) (models.Poll, error) {
+	var poll models.Poll
+	query := `SELECT id, question, multiple, anonymous, closes_at, (SELECT COUNT(*) FROM poll_voters WHERE poll = polls.id) AS voters
+		FROM polls WHERE thread = $1`
+	if err := p.DB.Get(&poll, query, thread); err != nil {
+		return models.Poll{}, err
+	}
+
+	query = `SELECT o.id, o.text, COUNT(c.nickname) AS votes,
+			CASE WHEN $2 THEN '{}' ELSE array_remove(array_agg(c.nickname::text ORDER BY c.nickname), NULL) END AS voters
+		FROM poll_options o LEFT JOIN poll_choices c ON c.option = o.id
+		WHERE o.poll = $1
+		GROUP BY o.id
+		ORDER BY o.position`
+	rows, err := p.DB.Query(query, poll.ID, poll.Anonymous)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	defer rows.Close()
+
+	poll.Options = make([]models.PollOption, 0)
+	for rows.Next() {
+		var option models.PollOption
+		if err = rows.Scan(&option.ID, &option.Text, &option.Votes, pq.Array(&option.Voters)); err != nil {
+			return models.Poll{}, err
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	if err = rows.Err(); err != nil {
+		return models.Poll{}, err
+	}
+
+	if viewer != "" {
+		query = `SELECT option FROM poll_choices WHERE poll = $1 AND nickname = $2 ORDER BY option`
+		if err = p.DB.Select(&poll.Choice, query, poll.ID, viewer); err != nil {
+			return models.Poll{}, err
+		}
+	}
+	return poll, nil
+}
+
+// CastBallot records or replaces the ballot of a user. The poll_voters row
+// is locked first, so concurrent ballots of one user are applied one after
+// the other. Options that are not in the poll are ErrInvalidBallot.
+func (p Postgres) CastBallot(poll uint64, ballot models.Ballot) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO poll_voters (poll, nickname) VALUES ($1, $2)
+		ON CONFLICT (poll, nickname) DO UPDATE SET updated = NOW()`
+	if _, err = tx.Exec(query, poll, ballot.Nickname); err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return e.ErrUserNotFound
+		}
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM poll_choices WHERE poll = $1 AND nickname = $2`, poll, ballot.Nickname); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO poll_choices (poll, nickname, option)
+		SELECT $1, $2, id FROM poll_options WHERE poll = $1 AND id = ANY($3)`
+	result, err := tx.Exec(query, poll, ballot.Nickname, pq.Array(ballot.Options))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(ballot.Options)) {
+		return e.ErrInvalidBallot
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+	"time"
+)
+
+// startCursor is the value part of a ThreadCursor with Start set.
+const startCursor = "start"
+
+// encodeStartCursor points at the first regular thread of sortBy.
+func encodeStartCursor(sortBy string) string {
+	raw := fmt.Sprintf("%s:%s:0", sortBy, startCursor)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// encodeThreadCursor packs the sort key, its value and the thread id so a
+// cursor can't be replayed against another sort order.
+func encodeThreadCursor(thread models.Thread, sortBy string) string {
+	var value string
+	switch sortBy {
+	case models.ThreadSortVotes:
+		value = strconv.FormatInt(thread.Votes, 10)
+	case models.ThreadSortReplies:
+		value = strconv.FormatInt(thread.Replies, 10)
+	case models.ThreadSortHot:
+		value = strconv.FormatFloat(thread.Hot, 'g', -1, 64)
+	case models.ThreadSortLastPost:
+		value = strconv.FormatInt(thread.LastPostAt.UnixNano(), 10)
+	default:
+		value = strconv.FormatInt(thread.Created.UnixNano(), 10)
+	}
+	raw := fmt.Sprintf("%s:%s:%d", sortBy, value, thread.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeThreadCursor(cursor, sortBy string) (*models.ThreadCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, e.ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || parts[0] != sortBy {
+		return nil, e.ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return nil, e.ErrInvalidCursor
+	}
+
+	res := &models.ThreadCursor{ID: id}
+	if parts[1] == startCursor {
+		res.Start = true
+		return res, nil
+	}
+	switch sortBy {
+	case models.ThreadSortHot:
+		res.Score, err = strconv.ParseFloat(parts[1], 64)
+	case models.ThreadSortVotes, models.ThreadSortReplies:
+		res.Count, err = strconv.ParseInt(parts[1], 10, 64)
+	default:
+		var nanos int64
+		nanos, err = strconv.ParseInt(parts[1], 10, 64)
+		res.At = time.Unix(0, nanos)
+	}
+	if err != nil {
+		return nil, e.ErrInvalidCursor
+	}
+	return res, nil
+}
@@ -0,0 +1,133 @@
+package usecase
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	e "technopark_db_forum/pkg/errors"
+	"time"
+)
+
+const (
+	minPollOptions = 2
+	maxPollOptions = 20
+)
+
+// normalizePoll trims the question and options of a new poll and checks
+// that there are 2 to 20 distinct options and that it closes in the future.
+func normalizePoll(poll *models.Poll, now time.Time) error {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if poll.Question == "" {
+		return e.ErrInvalidPoll
+	}
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return e.ErrInvalidPoll
+	}
+
+	seen := make(map[string]bool, len(poll.Options))
+	for i := range poll.Options {
+		text := strings.TrimSpace(poll.Options[i].Text)
+		key := strings.ToLower(text)
+		if text == "" || seen[key] {
+			return e.ErrInvalidPoll
+		}
+		seen[key] = true
+		poll.Options[i] = models.PollOption{Text: text}
+	}
+
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(now) {
+		return e.ErrInvalidPoll
+	}
+	return nil
+}
+
+// loadPoll returns the poll of a thread as seen by viewer, or nil when the
+// thread has none.
+func (u usecase) loadPoll(thread uint64, viewer string) (*models.Poll, error) {
+	poll, err := u.threadRepository.GetPoll(thread, viewer)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	poll.Closed = poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now())
+	return &poll, nil
+}
+
+// GetPoll returns the poll of a thread with its results.
+func (u usecase) GetPoll(slugOrID, viewer string) (models.Poll, error) {
+	thread, err := u.readableThread(slugOrID, viewer)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	poll, err := u.loadPoll(thread.ID, viewer)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	if poll == nil {
+		return models.Poll{}, e.ErrPollNotFound
+	}
+	return *poll, nil
+}
+
+// CastBallot records the ballot of a registered user who may vote in the
+// forum, replacing their earlier one, and returns the updated results.
+func (u usecase) CastBallot(slugOrID string, ballot models.Ballot) (models.Poll, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	if _, err = u.userRepository.GetUserByNickname(ballot.Nickname); err != nil {
+		return models.Poll{}, e.ErrUserNotFound
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	if forum.Archived {
+		return models.Poll{}, e.ErrForumArchived
+	}
+	if err = u.policy.AuthorizeAccess(ballot.Nickname, forum, policy.Vote); err != nil {
+		return models.Poll{}, err
+	}
+	if err = writable(thread); err != nil {
+		return models.Poll{}, err
+	}
+
+	poll, err := u.loadPoll(thread.ID, "")
+	if err != nil {
+		return models.Poll{}, err
+	}
+	if poll == nil {
+		return models.Poll{}, e.ErrPollNotFound
+	}
+	if poll.Closed {
+		return models.Poll{}, e.ErrPollClosed
+	}
+
+	options := make([]uint64, 0, len(ballot.Options))
+	seen := make(map[uint64]bool, len(ballot.Options))
+	for _, option := range ballot.Options {
+		if !seen[option] {
+			seen[option] = true
+			options = append(options, option)
+		}
+	}
+	if len(options) == 0 || (!poll.Multiple && len(options) > 1) {
+		return models.Poll{}, e.ErrInvalidBallot
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i] < options[j] })
+	ballot.Options = options
+
+	if err = u.threadRepository.CastBallot(poll.ID, ballot); err != nil {
+		return models.Poll{}, err
+	}
+	poll, err = u.loadPoll(thread.ID, ballot.Nickname)
+	if err != nil {
+		return models.Poll{}, err
+	}
+	return *poll, nil
+}
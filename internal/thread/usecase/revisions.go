@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"fmt"
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/internal/revision"
+)
+
+// readableThread finds a thread the viewer may read.
+func (u usecase) readableThread(slugOrID, viewer string) (models.Thread, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return models.Thread{}, err
+	}
+	return thread, nil
+}
+
+// GetRevisions lists the edit history of a thread, oldest first.
+func (u usecase) GetRevisions(slugOrID, viewer string) ([]models.Revision, error) {
+	thread, err := u.readableThread(slugOrID, viewer)
+	if err != nil {
+		return nil, err
+	}
+	return u.threadRepository.GetThreadRevisions(thread.ID)
+}
+
+// DiffRevisions renders a unified diff between two revisions of a thread,
+// see revision.Pick for the defaults.
+func (u usecase) DiffRevisions(slugOrID string, from, to uint64, viewer string) (string, error) {
+	thread, err := u.readableThread(slugOrID, viewer)
+	if err != nil {
+		return "", err
+	}
+	revisions, err := u.threadRepository.GetThreadRevisions(thread.ID)
+	if err != nil {
+		return "", err
+	}
+	a, b, err := revision.Pick(revisions, from, to)
+	if err != nil {
+		return "", err
+	}
+	return revision.Diff(fmt.Sprintf("thread %d", thread.ID), a, b), nil
+}
+
+// RollbackRevision restores the title and message of an earlier revision as
+// a new one, so the rollback itself stays in the history. Moderators of the
+// forum may do it.
+func (u usecase) RollbackRevision(slugOrID string, version uint64, actor string) (models.ThreadNoVotes, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.RollbackRevision, ""); err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+
+	revisions, err := u.threadRepository.GetThreadRevisions(thread.ID)
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	rev, err := revision.Find(revisions, version)
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+
+	restored := models.Thread{Title: rev.Title, Message: rev.Message}
+	return u.UpdateThread(restored, fmt.Sprint(thread.ID), actor, 0)
+}
@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	e "technopark_db_forum/pkg/errors"
+)
+
+const (
+	maxThreadTags = 10
+	maxTagLength  = 32
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_.+#-]*$`)
+
+// normalizeTag lowercases and trims a tag; an empty or malformed one is
+// ErrInvalidTag.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if len([]rune(tag)) > maxTagLength || !tagPattern.MatchString(tag) {
+		return "", e.ErrInvalidTag
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes, dedupes and sorts tags. nil stays nil so updates
+// can tell "leave the tags" from "remove all tags".
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(tags))
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	if len(res) > maxThreadTags {
+		return nil, e.ErrInvalidTag
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// attachTags loads the tags of the threads in one query.
+func (u usecase) attachTags(threads []models.Thread) error {
+	if len(threads) == 0 {
+		return nil
+	}
+	ids := make([]uint64, len(threads))
+	for i, thread := range threads {
+		ids[i] = thread.ID
+	}
+
+	tags, err := u.threadRepository.GetThreadTags(ids)
+	if err != nil {
+		return err
+	}
+	for i := range threads {
+		threads[i].Tags = tags[threads[i].ID]
+	}
+	return nil
+}
+
+// GetTags counts the tagged threads per tag in a forum the viewer can read,
+// or across the public forums when forum is empty.
+func (u usecase) GetTags(forum, viewer string, limit uint64) ([]models.TagCount, error) {
+	if forum == "" {
+		return u.threadRepository.GetTags("", limit)
+	}
+
+	f, err := u.forumRepository.GetForumBySlug(forum)
+	if err != nil {
+		return nil, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, f, policy.Read); err != nil {
+		return nil, err
+	}
+	return u.threadRepository.GetTags(f.Slug, limit)
+}
+
+// MergeTags retags every thread of the forum carrying one of merge.From with
+// merge.To and returns the forum's tag counts afterwards. Moderators and the
+// forum owner may do it.
+func (u usecase) MergeTags(slug string, merge models.TagMerge, actor string) ([]models.TagCount, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.ManageTags, ""); err != nil {
+		return nil, err
+	}
+
+	if len(merge.From) == 0 {
+		return nil, e.ErrInvalidTag
+	}
+	if merge.From, err = normalizeTags(merge.From); err != nil {
+		return nil, err
+	}
+	if merge.To, err = normalizeTag(merge.To); err != nil {
+		return nil, err
+	}
+
+	if err = u.threadRepository.MergeTags(forum.Slug, merge, actor); err != nil {
+		return nil, err
+	}
+	return u.threadRepository.GetTags(forum.Slug, 0)
+}
@@ -5,9 +5,12 @@ import (
 	"strconv"
 	forumRepository "technopark_db_forum/internal/forum/repository"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/internal/ranking"
 	threadRepository "technopark_db_forum/internal/thread/repository"
 	userRepository "technopark_db_forum/internal/users/repository"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/slug"
 	"time"
 
 	"github.com/jinzhu/copier"
@@ -16,24 +19,54 @@ import (
 type ThreadUsecase interface {
 	CreateThread(thread models.Thread) (models.Thread, error)
 	GetThreadBySlug(slugOrID string) (models.Thread, error)
-	GetThreadMsgsBySlug(slug string, since time.Time, options models.ThreadOptions) ([]models.Thread, error)
+	GetThreadMsgsBySlug(slug string, since time.Time, options models.ThreadOptions, cursor string) ([]models.Thread, string, error)
 	GetThreadByID(id uint64) (models.Thread, error)
-	UpdateThread(thread models.Thread, slugOrID string) (models.ThreadNoVotes, error)
+	UpdateThread(thread models.Thread, slugOrID, actor string, version uint64) (models.ThreadNoVotes, error)
 	CreateVote(vote models.Vote, slugOrID string) (models.Thread, error)
-	GetThread(slugOrID string) (models.Thread, error)
+	GetThread(slugOrID, viewer string) (models.Thread, error)
+	SetThreadState(slugOrID string, state models.ThreadState, actor string) (models.Thread, error)
+
+	GetTags(forum, viewer string, limit uint64) ([]models.TagCount, error)
+	MergeTags(slug string, merge models.TagMerge, actor string) ([]models.TagCount, error)
+
+	GetTrending(options models.TrendingOptions) ([]models.TrendingThread, error)
+	RefreshRankings(now time.Time) (int, error)
+	RunRankings(interval time.Duration, stop <-chan struct{})
+
+	RetractVote(slugOrID, nickname string) (models.VoteState, error)
+	GetVote(slugOrID, nickname string) (models.VoteState, error)
+	GetVotes(slugOrID, viewer string, options models.VoteOptions) ([]models.Vote, error)
+
+	DeleteThread(slugOrID, actor string) error
+	MoveThread(slugOrID, forum, actor string) (models.Thread, error)
+	MergeThread(slugOrID, into, actor string) (models.ThreadMergeResult, error)
+
+	GetRevisions(slugOrID, viewer string) ([]models.Revision, error)
+	DiffRevisions(slugOrID string, from, to uint64, viewer string) (string, error)
+	RollbackRevision(slugOrID string, version uint64, actor string) (models.ThreadNoVotes, error)
+
+	GetPoll(slugOrID, viewer string) (models.Poll, error)
+	CastBallot(slugOrID string, ballot models.Ballot) (models.Poll, error)
 }
 
 type usecase struct {
 	threadRepository threadRepository.ThreadRepository
 	userRepository   userRepository.UserRepository
 	forumRepository  forumRepository.ForumRepository
+	policy           policy.Enforcer
+	rankers          map[string]ranking.Ranker
 }
 
-func NewThreadUsecase(threadRepo threadRepository.ThreadRepository, userRepo userRepository.UserRepository, forumRepo forumRepository.ForumRepository) ThreadUsecase {
+// NewThreadUsecase builds the thread usecase; rankers are the trending
+// formulas by name, see ranking.Default.
+func NewThreadUsecase(threadRepo threadRepository.ThreadRepository, userRepo userRepository.UserRepository, forumRepo forumRepository.ForumRepository,
+	rankers map[string]ranking.Ranker) ThreadUsecase {
 	return &usecase{
 		threadRepository: threadRepo,
 		userRepository:   userRepo,
 		forumRepository:  forumRepo,
+		policy:           policy.NewEnforcer(userRepo, forumRepo),
+		rankers:          rankers,
 	}
 }
 
@@ -42,13 +75,39 @@ func (u usecase) CreateThread(thread models.Thread) (models.Thread, error) {
 	if err != nil {
 		return models.Thread{}, e.ErrThreadNotFound
 	}
+	if forum.Archived {
+		return models.Thread{}, e.ErrForumArchived
+	}
 
 	_, err = u.userRepository.GetUserByNickname(thread.Author)
 	if err != nil {
 		return models.Thread{}, e.ErrConflictNickname
 	}
 
-	if thread.Slug != "" {
+	banned, err := u.forumRepository.IsBanned(forum.Slug, thread.Author)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	if banned {
+		return models.Thread{}, e.ErrBanned
+	}
+	if err = u.policy.AuthorizeAccess(thread.Author, forum, policy.StartThread); err != nil {
+		return models.Thread{}, err
+	}
+	if thread.Tags, err = normalizeTags(thread.Tags); err != nil {
+		return models.Thread{}, err
+	}
+	if thread.Poll != nil {
+		if err = normalizePoll(thread.Poll, time.Now()); err != nil {
+			return models.Thread{}, err
+		}
+	}
+
+	generated := thread.Slug == ""
+	if !generated {
+		if !slug.Valid(thread.Slug) {
+			return models.Thread{}, e.ErrInvalidSlug
+		}
 		th, err := u.threadRepository.GetThreadBySlug(thread.Slug)
 		if err == nil {
 			return th, e.ErrDuplicate
@@ -60,17 +119,26 @@ func (u usecase) CreateThread(thread models.Thread) (models.Thread, error) {
 
 	thread.Forum = forum.Slug
 
-	res, err := u.threadRepository.CreateThread(thread)
-	if err != nil {
-		return models.Thread{}, err
-	}
+	for attempt := 1; ; attempt++ {
+		if generated {
+			if thread.Slug, err = u.threadRepository.FreeSlug(slug.Make(thread.Title, "thread")); err != nil {
+				return models.Thread{}, err
+			}
+		}
 
-	_, err = u.forumRepository.CreateForumUser(thread.Forum, thread.Author)
-	if err != nil {
-		return models.Thread{}, err
+		res, err := u.threadRepository.CreateThread(thread)
+		if err == e.ErrDuplicate {
+			if generated && attempt < slug.MaxAttempts {
+				continue
+			}
+			if !generated {
+				if th, err := u.threadRepository.GetThreadBySlug(thread.Slug); err == nil {
+					return th, e.ErrDuplicate
+				}
+			}
+		}
+		return res, err
 	}
-
-	return res, nil
 }
 
 func (u usecase) GetThreadBySlug(slugOrID string) (models.Thread, error) {
@@ -81,17 +149,51 @@ func (u usecase) GetThreadBySlug(slugOrID string) (models.Thread, error) {
 	return thread, nil
 }
 
-func (u usecase) GetThreadMsgsBySlug(slugOrID string, since time.Time, options models.ThreadOptions) ([]models.Thread, error) {
-	_, err := u.forumRepository.GetForumBySlug(slugOrID)
+// GetThreadMsgsBySlug returns a page of forum threads and, when the page is
+// full, the cursor of the next one. since is the legacy created-only bound.
+func (u usecase) GetThreadMsgsBySlug(slugOrID string, since time.Time, options models.ThreadOptions, cursor string) ([]models.Thread, string, error) {
+	forum, err := u.forumRepository.GetForumBySlug(slugOrID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if err = u.policy.AuthorizeAccess(options.Viewer, forum, policy.Read); err != nil {
+		return nil, "", err
 	}
 
-	threads, err := u.threadRepository.GetThreadMsgs(slugOrID, since, options)
+	if options.Tag != "" {
+		if options.Tag, err = normalizeTag(options.Tag); err != nil {
+			return nil, "", err
+		}
+	}
+	if since != (time.Time{}) && options.SortBy != models.ThreadSortCreated {
+		return nil, "", e.ErrInvalidCursor
+	}
+	if cursor != "" {
+		if options.Cursor, err = decodeThreadCursor(cursor, options.SortBy); err != nil {
+			return nil, "", err
+		}
+	}
+
+	pinned, regular, err := u.threadRepository.GetThreadMsgs(slugOrID, since, options)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	threads := append(pinned, regular...)
+	if err = u.attachTags(threads); err != nil {
+		return nil, "", err
+	}
+
+	// The cursor continues the regular order; pinned threads are only ever
+	// on the first page.
+	next := ""
+	if options.Limit != 0 && uint64(len(threads)) >= options.Limit {
+		if len(regular) == 0 {
+			next = encodeStartCursor(options.SortBy)
+		} else {
+			next = encodeThreadCursor(regular[len(regular)-1], options.SortBy)
+		}
 	}
-	return threads, nil
+	return threads, next, nil
 }
 
 func (u usecase) GetThreadByID(id uint64) (models.Thread, error) {
@@ -102,7 +204,11 @@ func (u usecase) GetThreadByID(id uint64) (models.Thread, error) {
 	return thread, nil
 }
 
-func (u usecase) UpdateThread(thread models.Thread, slugOrID string) (models.ThreadNoVotes, error) {
+// UpdateThread applies a non-empty title or message and, when set, replaces
+// the tags. A non-zero version is the one the client last saw (If-Match); a
+// mismatch is ErrPreconditionFailed. As with posts, the actor has to be the
+// author or moderate the forum.
+func (u usecase) UpdateThread(thread models.Thread, slugOrID, actor string, version uint64) (models.ThreadNoVotes, error) {
 	try, err := strconv.ParseUint(slugOrID, 10, 64)
 	var th models.Thread
 	if err == nil {
@@ -118,7 +224,33 @@ func (u usecase) UpdateThread(thread models.Thread, slugOrID string) (models.Thr
 		thread.Slug = slugOrID
 	}
 
-	if thread.Message == "" && thread.Title == "" {
+	if version != 0 && th.Version != version {
+		return models.ThreadNoVotes{}, e.ErrPreconditionFailed
+	}
+	if th.Status == models.ThreadClosed {
+		return models.ThreadNoVotes{}, e.ErrThreadClosed
+	}
+
+	forum, err := u.forumRepository.GetForumBySlug(th.Forum)
+	if err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.EditPost, th.Author); err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+
+	if thread.Tags, err = normalizeTags(thread.Tags); err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	current := []models.Thread{th}
+	if err = u.attachTags(current); err != nil {
+		return models.ThreadNoVotes{}, err
+	}
+	th.Tags = current[0].Tags
+
+	unchanged := (thread.Message == "" || thread.Message == th.Message) && (thread.Title == "" || thread.Title == th.Title) &&
+		(thread.Tags == nil || sameTags(thread.Tags, th.Tags))
+	if unchanged {
 		return models.ThreadNoVotes{
 			ID:      th.ID,
 			Title:   th.Title,
@@ -127,18 +259,29 @@ func (u usecase) UpdateThread(thread models.Thread, slugOrID string) (models.Thr
 			Message: th.Message,
 			Slug:    th.Slug,
 			Created: th.Created.UTC(),
+			Version: th.Version,
+			Tags:    th.Tags,
 		}, nil
 	}
 
+	id := th.ID
 	if err = copier.CopyWithOption(&th, &thread, copier.Option{IgnoreEmpty: true}); err != nil {
 		return models.ThreadNoVotes{}, err
 	}
+	th.ID = id
+	// copier skips empty slices, so an explicit "tags": [] is applied here;
+	// nil leaves the stored tags alone.
+	tags := th.Tags
+	th.Tags = thread.Tags
 
 	thread.ID = th.ID
 	thread.Slug = th.Slug
 
-	res, err := u.threadRepository.UpdateThread(th)
+	res, err := u.threadRepository.UpdateThread(th, version, actor)
 	if err != nil {
+		if err == sql.ErrNoRows && version != 0 {
+			return models.ThreadNoVotes{}, e.ErrPreconditionFailed
+		}
 		if err == sql.ErrNoRows {
 			return models.ThreadNoVotes{
 				ID:      th.ID,
@@ -148,24 +291,68 @@ func (u usecase) UpdateThread(thread models.Thread, slugOrID string) (models.Thr
 				Message: th.Message,
 				Slug:    th.Slug,
 				Created: th.Created.UTC(),
+				Tags:    tags,
 			}, nil
 		}
 		return models.ThreadNoVotes{}, err
 	}
+	if res.Tags == nil {
+		res.Tags = tags
+	}
 
 	return res, nil
 }
 
+// CreateVote casts or changes a vote; a voice of 0 retracts it.
 func (u usecase) CreateVote(vote models.Vote, slugOrID string) (models.Thread, error) {
-	id, err := strconv.ParseUint(slugOrID, 10, 64)
+	if vote.VoiceValue < -1 || vote.VoiceValue > 1 {
+		return models.Thread{}, e.ErrInvalidVote
+	}
+	thread, err := u.votableThread(slugOrID, vote.Nickname)
 	if err != nil {
-		return u.threadRepository.VoteBySlug(slugOrID, vote)
+		return models.Thread{}, err
 	}
 
-	return u.threadRepository.VoteByID(id, vote)
+	if vote.VoiceValue == 0 {
+		if _, err = u.userRepository.GetUserByNickname(vote.Nickname); err != nil {
+			return models.Thread{}, e.ErrUserNotFound
+		}
+		if _, err = u.threadRepository.RetractVote(thread.ID, vote.Nickname); err != nil {
+			return models.Thread{}, err
+		}
+		if thread, err = u.threadRepository.GetThreadByID(thread.ID); err != nil {
+			return models.Thread{}, err
+		}
+		thread.Voice = &vote.VoiceValue
+		return thread, nil
+	}
+	return u.threadRepository.VoteByID(thread.ID, vote)
 }
 
-func (u usecase) GetThread(slugOrID string) (models.Thread, error) {
+func (u usecase) GetThread(slugOrID, viewer string) (models.Thread, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	if err = u.policy.AuthorizeAccess(viewer, forum, policy.Read); err != nil {
+		return models.Thread{}, err
+	}
+
+	threads := []models.Thread{thread}
+	if err = u.attachTags(threads); err != nil {
+		return models.Thread{}, err
+	}
+	if threads[0].Poll, err = u.loadPoll(thread.ID, viewer); err != nil {
+		return models.Thread{}, err
+	}
+	return threads[0], nil
+}
+
+func (u usecase) findThread(slugOrID string) (models.Thread, error) {
 	try, err := strconv.ParseUint(slugOrID, 10, 64)
 	if err == nil {
 		thread, err := u.threadRepository.GetThreadByID(try)
@@ -181,3 +368,160 @@ func (u usecase) GetThread(slugOrID string) (models.Thread, error) {
 	}
 	return thread, nil
 }
+
+// writable rejects new posts and votes in locked and closed threads.
+func writable(thread models.Thread) error {
+	switch thread.Status {
+	case models.ThreadLocked:
+		return e.ErrThreadLocked
+	case models.ThreadClosed:
+		return e.ErrThreadClosed
+	default:
+		return nil
+	}
+}
+
+// SetThreadState locks, closes, reopens or pins a thread, which moderators of
+// its forum may do, or makes it an announcement, which only admins may.
+func (u usecase) SetThreadState(slugOrID string, state models.ThreadState, actor string) (models.Thread, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.Thread{}, err
+	}
+
+	switch state.Status {
+	case "":
+	case models.ThreadOpen, models.ThreadLocked, models.ThreadClosed:
+		thread.Status = state.Status
+	default:
+		return models.Thread{}, e.ErrInvalidState
+	}
+	if state.Status != "" || state.Pinned != nil {
+		if err = u.policy.Authorize(actor, forum, policy.LockThread, ""); err != nil {
+			return models.Thread{}, err
+		}
+	}
+	if state.Pinned != nil {
+		thread.Pinned = *state.Pinned
+	}
+	if state.Announcement != nil {
+		if err = u.policy.Authorize(actor, forum, policy.Announce, ""); err != nil {
+			return models.Thread{}, err
+		}
+		thread.Announcement = *state.Announcement
+	}
+	if state.Status == "" && state.Pinned == nil && state.Announcement == nil {
+		return models.Thread{}, e.ErrInvalidState
+	}
+
+	return u.threadRepository.SetThreadState(thread, actor)
+}
+
+// DeleteThread removes a thread with everything in it. Moderators of its
+// forum may do it.
+func (u usecase) DeleteThread(slugOrID, actor string) error {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.DeleteThread, ""); err != nil {
+		return err
+	}
+	return u.threadRepository.DeleteThread(thread, actor)
+}
+
+// MoveThread moves a thread with its posts into another forum. The actor has
+// to moderate both forums, and archived forums take no threads.
+func (u usecase) MoveThread(slugOrID, forum, actor string) (models.Thread, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	source, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	target, err := u.forumRepository.GetForumBySlug(forum)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Thread{}, e.ErrForumNotFound
+		}
+		return models.Thread{}, err
+	}
+
+	if err = u.policy.Authorize(actor, source, policy.MoveThread, ""); err != nil {
+		return models.Thread{}, err
+	}
+	if err = u.policy.Authorize(actor, target, policy.MoveThread, ""); err != nil {
+		return models.Thread{}, err
+	}
+	if target.Archived {
+		return models.Thread{}, e.ErrForumArchived
+	}
+	if target.Slug == source.Slug {
+		return thread, nil
+	}
+
+	return u.threadRepository.MoveThread(thread, target.Slug, actor)
+}
+
+// MergeThread moves the posts and tags of a duplicate thread into another
+// one and deletes the duplicate; its opening message becomes a root post and
+// its votes are dropped. The actor has to moderate both forums, and the target
+// has to take new posts.
+func (u usecase) MergeThread(slugOrID, into, actor string) (models.ThreadMergeResult, error) {
+	from, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	target, err := u.findThread(into)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.ThreadMergeResult{}, e.ErrThreadNotFound
+		}
+		return models.ThreadMergeResult{}, err
+	}
+	if from.ID == target.ID {
+		return models.ThreadMergeResult{}, e.ErrInvalidMerge
+	}
+
+	source, err := u.forumRepository.GetForumBySlug(from.Forum)
+	if err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	if err = u.policy.Authorize(actor, source, policy.MergeThread, ""); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(target.Forum)
+	if err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	if err = u.policy.Authorize(actor, forum, policy.MergeThread, ""); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	if forum.Archived {
+		return models.ThreadMergeResult{}, e.ErrForumArchived
+	}
+	if err = writable(target); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+
+	res, err := u.threadRepository.MergeThread(from, target, actor)
+	if err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	threads := []models.Thread{res.Thread}
+	if err = u.attachTags(threads); err != nil {
+		return models.ThreadMergeResult{}, err
+	}
+	res.Thread = threads[0]
+	return res, nil
+}
@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/internal/ranking"
+	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/logger"
+	"time"
+)
+
+const (
+	// MaxTrendingWindow also bounds how far back decaying scores are kept
+	// fresh, older threads can't show up in a trending listing anyway.
+	MaxTrendingWindow = 7 * 24 * time.Hour
+
+	// rankingStale is how old the score of an active thread may get before
+	// the job rescores it for the passing time alone.
+	rankingStale = 10 * time.Minute
+	rankingBatch = 500
+)
+
+// GetTrending lists the threads of a forum the viewer can read, or of all
+// public forums, that were active within the window, best scored first.
+func (u usecase) GetTrending(options models.TrendingOptions) ([]models.TrendingThread, error) {
+	if _, ok := u.rankers[options.Ranker]; !ok {
+		return nil, e.ErrInvalidRanking
+	}
+	if options.Window <= 0 || options.Window > MaxTrendingWindow {
+		return nil, e.ErrInvalidRanking
+	}
+
+	if options.Forum != "" {
+		forum, err := u.forumRepository.GetForumBySlug(options.Forum)
+		if err != nil {
+			return nil, err
+		}
+		if err = u.policy.AuthorizeAccess(options.Viewer, forum, policy.Read); err != nil {
+			return nil, err
+		}
+		options.Forum = forum.Slug
+	}
+
+	trending, err := u.threadRepository.GetTrending(options, time.Now().Add(-options.Window))
+	if err != nil {
+		return nil, err
+	}
+
+	threads := make([]models.Thread, len(trending))
+	for i := range trending {
+		threads[i] = trending[i].Thread
+	}
+	if err = u.attachTags(threads); err != nil {
+		return nil, err
+	}
+	for i := range trending {
+		trending[i].Tags = threads[i].Tags
+	}
+	return trending, nil
+}
+
+// RefreshRankings rescores every thread that got votes or posts since it was
+// last scored and, when some ranker decays, the recently active threads with
+// old scores. It returns the number of threads scored.
+func (u usecase) RefreshRankings(now time.Time) (int, error) {
+	var horizon time.Time
+	for _, ranker := range u.rankers {
+		if ranker.Decays() {
+			horizon = now.Add(-MaxTrendingWindow)
+		}
+	}
+
+	score := func(signals []models.ThreadSignals) []models.ThreadScore {
+		scores := make([]models.ThreadScore, 0, len(signals)*len(u.rankers))
+		for _, thread := range signals {
+			for name, ranker := range u.rankers {
+				scores = append(scores, models.ThreadScore{
+					Thread: thread.ID,
+					Ranker: name,
+					Forum:  thread.Forum,
+					Score:  ranker.Score(thread, now),
+				})
+			}
+		}
+		return scores
+	}
+
+	total := 0
+	for {
+		n, err := u.threadRepository.Rescore(now, horizon, now.Add(-rankingStale), now.Add(-ranking.VelocityWindow), rankingBatch, score)
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if n < rankingBatch {
+			return total, nil
+		}
+	}
+}
+
+// RunRankings refreshes the rankings every interval until stop is closed.
+func (u usecase) RunRankings(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if _, err := u.RefreshRankings(now); err != nil {
+				logger.GetInstance().Errorf("refresh rankings: %s", err)
+			}
+		}
+	}
+}
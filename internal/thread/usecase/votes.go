@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	e "technopark_db_forum/pkg/errors"
+)
+
+// votableThread finds a thread nickname may vote in: its forum is not
+// archived, lets them vote, and the thread is neither locked nor closed.
+func (u usecase) votableThread(slugOrID, nickname string) (models.Thread, error) {
+	thread, err := u.findThread(slugOrID)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	forum, err := u.forumRepository.GetForumBySlug(thread.Forum)
+	if err != nil {
+		return models.Thread{}, err
+	}
+	if forum.Archived {
+		return models.Thread{}, e.ErrForumArchived
+	}
+	if err = u.policy.AuthorizeAccess(nickname, forum, policy.Vote); err != nil {
+		return models.Thread{}, err
+	}
+	if err = writable(thread); err != nil {
+		return models.Thread{}, err
+	}
+	return thread, nil
+}
+
+// RetractVote removes the user's vote from a thread. Retracting a vote that
+// was never cast is not an error.
+func (u usecase) RetractVote(slugOrID, nickname string) (models.VoteState, error) {
+	thread, err := u.votableThread(slugOrID, nickname)
+	if err != nil {
+		return models.VoteState{}, err
+	}
+	if _, err = u.userRepository.GetUserByNickname(nickname); err != nil {
+		return models.VoteState{}, e.ErrUserNotFound
+	}
+	return u.threadRepository.RetractVote(thread.ID, nickname)
+}
+
+// GetVote returns the user's vote on a thread they can read.
+func (u usecase) GetVote(slugOrID, nickname string) (models.VoteState, error) {
+	thread, err := u.readableThread(slugOrID, nickname)
+	if err != nil {
+		return models.VoteState{}, err
+	}
+	if _, err = u.userRepository.GetUserByNickname(nickname); err != nil {
+		return models.VoteState{}, e.ErrUserNotFound
+	}
+	return u.threadRepository.GetVote(thread.ID, nickname)
+}
+
+// GetVotes lists who voted on a thread and how.
+func (u usecase) GetVotes(slugOrID, viewer string, options models.VoteOptions) ([]models.Vote, error) {
+	thread, err := u.readableThread(slugOrID, viewer)
+	if err != nil {
+		return nil, err
+	}
+	return u.threadRepository.GetVotes(thread.ID, options)
+}
@@ -2,11 +2,15 @@ package delivery
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"technopark_db_forum/internal/models"
 	"technopark_db_forum/internal/users/usecase"
 	e "technopark_db_forum/pkg/errors"
+	"technopark_db_forum/pkg/etag"
 
 	"github.com/labstack/echo/v4"
 )
@@ -43,11 +47,21 @@ func (h UserHandler) CreateUser(c echo.Context) error {
 func (h UserHandler) GetUser(c echo.Context) error {
 	nickname := c.Param("nickname")
 
-	user, err := h.userUsecase.GetUserByNickname(nickname)
+	user, err := h.userUsecase.GetUserProfile(nickname)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
 	}
-	return c.JSON(http.StatusOK, user)
+
+	withStats, err := strconv.ParseBool(c.QueryParam("stats"))
+	if err != nil || !withStats {
+		return etag.JSON(c, http.StatusOK, user.Version, user)
+	}
+
+	stats, err := h.userUsecase.GetUserStats(user.Nickname)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return etag.JSON(c, http.StatusOK, user.Version, models.UserProfile{User: user, Stats: &stats})
 }
 
 func (h UserHandler) UpdateUser(c echo.Context) error {
@@ -59,8 +73,16 @@ func (h UserHandler) UpdateUser(c echo.Context) error {
 	}
 	user.Nickname = nickname
 
-	updatedUser, err := h.userUsecase.UpdateUser(user)
+	version, err := etag.IfMatch(c)
 	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	updatedUser, err := h.userUsecase.UpdateUser(user, version)
+	if err != nil {
+		if err == e.ErrPreconditionFailed {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("User %s was modified, refetch the profile and retry", nickname))
+		}
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
 		}
@@ -72,5 +94,259 @@ func (h UserHandler) UpdateUser(c echo.Context) error {
 		}
 		return err
 	}
-	return c.JSON(http.StatusOK, updatedUser)
+	return etag.JSON(c, http.StatusOK, updatedUser.Version, updatedUser)
+}
+
+func (h UserHandler) RenameUser(c echo.Context) error {
+	nickname := c.Param("nickname")
+
+	var rename models.UserRename
+	if err := c.Bind(&rename); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	renamedUser, err := h.userUsecase.RenameUser(nickname, rename.Nickname)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		case e.ErrInvalidNickname:
+			return echo.NewHTTPError(http.StatusBadRequest, "New nickname must not be empty")
+		case e.ErrConflictNickname:
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("This nickname is already taken: %s", rename.Nickname))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return etag.JSON(c, http.StatusOK, renamedUser.Version, renamedUser)
+}
+
+func (h UserHandler) ExportUser(c echo.Context) error {
+	nickname := c.Param("nickname")
+	actor := c.QueryParam("actor")
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = models.ExportFormatNDJSON
+	}
+
+	var contentType string
+	switch format {
+	case models.ExportFormatNDJSON:
+		contentType = "application/x-ndjson"
+	case models.ExportFormatZIP:
+		contentType = "application/zip"
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown export format: %s", format))
+	}
+
+	user, err := h.userUsecase.GetUserByNickname(nickname)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+
+	// The status line goes out with the first record, so an authorization
+	// failure can still be reported; later failures only cut the stream short.
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", user.Nickname+"."+format))
+
+	err = h.userUsecase.ExportUser(user.Nickname, format, actor, res)
+	if err != nil && !res.Committed {
+		res.Header().Del(echo.HeaderContentDisposition)
+		switch err {
+		case e.ErrActorRequired:
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		case e.ErrForbidden:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to export %s", actor, user.Nickname))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return err
+}
+
+func (h UserHandler) ImportUsers(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = models.ImportFormatNDJSON
+		if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), "text/csv") {
+			format = models.ImportFormatCSV
+		}
+	}
+	dryRun, err := strconv.ParseBool(c.QueryParam("dry_run"))
+	if err != nil {
+		dryRun = false
+	}
+
+	result, err := h.userUsecase.ImportUsers(c.Request().Body, format, dryRun)
+	if err != nil {
+		if errors.Is(err, e.ErrInvalidFormat) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		result.Error = err.Error()
+		return c.JSON(http.StatusInternalServerError, result)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h UserHandler) Follow(c echo.Context) error {
+	nickname := c.Param("nickname")
+
+	var follow models.Follow
+	if err := c.Bind(&follow); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	err := h.userUsecase.Follow(nickname, follow)
+	if err != nil {
+		switch err {
+		case e.ErrInvalidFollow:
+			return echo.NewHTTPError(http.StatusBadRequest, "Exactly one of user or forum must be set and a user can't follow themselves")
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		case e.ErrUserNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", follow.User))
+		case e.ErrForumNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find forum with slug: %s", follow.Forum))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return h.GetFollowing(c)
+}
+
+func (h UserHandler) Unfollow(c echo.Context) error {
+	nickname := c.Param("nickname")
+
+	var follow models.Follow
+	if err := c.Bind(&follow); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	if err := h.userUsecase.Unfollow(nickname, follow); err != nil {
+		if err == e.ErrInvalidFollow {
+			return echo.NewHTTPError(http.StatusBadRequest, "Exactly one of user or forum must be set")
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return h.GetFollowing(c)
+}
+
+func (h UserHandler) GetFollowing(c echo.Context) error {
+	nickname := c.Param("nickname")
+
+	following, err := h.userUsecase.GetFollowing(nickname)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, following)
+}
+
+func (h UserHandler) GetFeed(c echo.Context) error {
+	nickname := c.Param("nickname")
+
+	limit, err := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit == 0 {
+		limit = 100
+	}
+
+	page, err := h.userUsecase.GetFeed(nickname, c.QueryParam("since"), limit)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		case e.ErrInvalidCursor:
+			return echo.NewHTTPError(http.StatusBadRequest, "Malformed feed cursor")
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func (h UserHandler) Mute(c echo.Context) error {
+	return h.setRelation(c, models.RelationMute, true)
+}
+
+func (h UserHandler) Unmute(c echo.Context) error {
+	return h.setRelation(c, models.RelationMute, false)
+}
+
+func (h UserHandler) Block(c echo.Context) error {
+	return h.setRelation(c, models.RelationBlock, true)
+}
+
+func (h UserHandler) Unblock(c echo.Context) error {
+	return h.setRelation(c, models.RelationBlock, false)
+}
+
+func (h UserHandler) setRelation(c echo.Context, kind string, on bool) error {
+	nickname := c.Param("nickname")
+
+	var target models.RelationTarget
+	if err := c.Bind(&target); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	relations, err := h.userUsecase.SetRelation(nickname, target.User, kind, on)
+	if err != nil {
+		switch err {
+		case e.ErrInvalidRelation:
+			return echo.NewHTTPError(http.StatusBadRequest, "Target user must be set and differ from the user")
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		case e.ErrUserNotFound:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", target.User))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, relations)
+}
+
+func (h UserHandler) GetRelations(c echo.Context) error {
+	nickname := c.Param("nickname")
+
+	relations, err := h.userUsecase.GetRelations(nickname)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, relations)
+}
+
+func (h UserHandler) SetRole(c echo.Context) error {
+	nickname := c.Param("nickname")
+	actor := c.QueryParam("actor")
+
+	var role models.UserRole
+	if err := c.Bind(&role); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	res, err := h.userUsecase.SetRole(nickname, role.Role, actor)
+	if err != nil {
+		switch err {
+		case e.ErrInvalidRole:
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown role: %s", role.Role))
+		case e.ErrActorRequired:
+			return echo.NewHTTPError(http.StatusUnauthorized, "Acting user is missing or unknown, pass it as ?actor=")
+		case e.ErrForbidden:
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("User %s is not allowed to change roles", actor))
+		case sql.ErrNoRows:
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Can't find user by nickname: %s", nickname))
+		default:
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+	}
+	return c.JSON(http.StatusOK, res)
 }
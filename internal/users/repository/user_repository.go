@@ -1,8 +1,14 @@
 package userRepository
 
 import (
+	"context"
+	"database/sql"
+	"strings"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	"technopark_db_forum/pkg/audit"
 	e "technopark_db_forum/pkg/errors"
+	"time"
 
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
@@ -14,8 +20,34 @@ type UserRepository interface {
 	CreateUser(user models.User) (models.User, error)
 	GetUserByNickname(nickname string) (models.User, error)
 	GetUserByEmail(email string) (models.User, error)
-	UpdateUser(user models.User) (models.User, error)
+	UpdateUser(user models.User, version uint64) (models.User, error)
 	GetUsersByEmailNickname(email, nickname string) ([]models.User, error)
+
+	RenameUser(oldNickname, newNickname string, redirectUntil time.Time) (models.User, error)
+	GetUserByRedirect(oldNickname string) (models.User, error)
+	GetUsersByRedirects(oldNicknames []string) (map[string]models.User, error)
+
+	ExportUser(nickname string, emit func(models.ExportRecord) error) error
+
+	GetUsersByEmailsNicknames(emails, nicknames []string) ([]models.User, error)
+	CreateUsers(users []models.User, dryRun bool) ([]string, map[string]error, error)
+
+	GetUserStats(nickname string) (models.UserStats, error)
+
+	FollowUser(follower, followee string) error
+	UnfollowUser(follower, followee string) error
+	FollowForum(follower, forum string) error
+	UnfollowForum(follower, forum string) error
+	GetFollowing(nickname string) (models.Following, error)
+	GetFeed(nickname string, cursor models.FeedCursor, limit uint64) ([]models.FeedItem, error)
+
+	AddRelation(nickname, target, kind string) error
+	RemoveRelation(nickname, target, kind string) error
+	GetRelations(nickname string) (models.UserRelations, error)
+	IsBlocked(blockers, nicknames []string) (bool, error)
+
+	GetUserRole(nickname string) (string, error)
+	SetUserRole(nickname, role, actor string) error
 }
 
 type Postgres struct {
@@ -43,13 +75,13 @@ func (p Postgres) GetUsersByEmailNickname(email, nickname string) ([]models.User
 
 func (p Postgres) CreateUser(user models.User) (models.User, error) {
 	var res models.User
-	query := `INSERT INTO users (nickname, fullname, email, about) VALUES ($1, $2, $3, $4) RETURNING *`
+	query := `INSERT INTO users (nickname, fullname, email, about) VALUES ($1, $2, $3, $4) RETURNING nickname, fullname, email, about`
 	err := p.DB.QueryRowx(query, user.Nickname, user.FullName, user.Email, user.About).Scan(&res.Nickname, &res.FullName, &res.Email, &res.About)
 	return res, err
 }
 
 func (p Postgres) GetUserByNickname(nickname string) (models.User, error) {
-	query := `SELECT nickname, fullname, email, about FROM users WHERE nickname = $1`
+	query := `SELECT nickname, fullname, email, about, version FROM users WHERE nickname = $1`
 	user := models.User{}
 	err := p.DB.Get(&user, query, nickname)
 	return user, err
@@ -62,10 +94,15 @@ func (p Postgres) GetUserByEmail(email string) (models.User, error) {
 	return user, err
 }
 
-func (p Postgres) UpdateUser(user models.User) (models.User, error) {
+// UpdateUser overwrites the profile. A non-zero version makes the update
+// conditional: if the row moved on meanwhile, nothing matches and
+// sql.ErrNoRows is returned.
+func (p Postgres) UpdateUser(user models.User, version uint64) (models.User, error) {
 	var res models.User
-	query := `UPDATE users SET fullname = $1, email = $2, about = $3 WHERE nickname = $4 RETURNING fullname, email, about, nickname`
-	err := p.DB.QueryRow(query, user.FullName, user.Email, user.About, user.Nickname).Scan(&res.FullName, &res.Email, &res.About, &res.Nickname)
+	query := `UPDATE users SET fullname = $1, email = $2, about = $3, version = version + 1
+		WHERE nickname = $4 AND ($5::bigint = 0 OR version = $5)
+		RETURNING fullname, email, about, nickname, version`
+	err := p.DB.QueryRow(query, user.FullName, user.Email, user.About, user.Nickname, version).Scan(&res.FullName, &res.Email, &res.About, &res.Nickname, &res.Version)
 	if err != nil {
 		pgErr, ok := err.(*pq.Error)
 		if ok {
@@ -81,3 +118,483 @@ func (p Postgres) UpdateUser(user models.User) (models.User, error) {
 	}
 	return res, err
 }
+
+// RenameUser changes the primary key of a user. Every table referencing
+// users(nickname) follows through ON UPDATE CASCADE, so the whole rename is a
+// single UPDATE plus the redirect bookkeeping, all in one transaction.
+func (p Postgres) RenameUser(oldNickname, newNickname string, redirectUntil time.Time) (models.User, error) {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var owner string
+	query := `SELECT nickname FROM user_redirects WHERE old_nickname = $1 AND expires > NOW() AND nickname <> $2`
+	err = tx.Get(&owner, query, newNickname, oldNickname)
+	if err == nil {
+		return models.User{}, e.ErrConflictNickname
+	}
+	if err != sql.ErrNoRows {
+		return models.User{}, err
+	}
+
+	var res models.User
+	query = `UPDATE users SET nickname = $1, version = version + 1 WHERE nickname = $2
+		RETURNING nickname, fullname, email, about, version`
+	err = tx.QueryRowx(query, newNickname, oldNickname).Scan(&res.Nickname, &res.FullName, &res.Email, &res.About, &res.Version)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23505" {
+			return models.User{}, e.ErrConflictNickname
+		}
+		return models.User{}, err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM user_redirects WHERE old_nickname = $1`, newNickname); err != nil {
+		return models.User{}, err
+	}
+
+	// A case-only change keeps resolving through citext, no redirect needed.
+	if _, err = tx.Exec(
+		`
+			INSERT INTO user_redirects (old_nickname, nickname, expires)
+			SELECT $1, $2, $3 WHERE $1::citext <> $2::citext
+			ON CONFLICT (old_nickname) DO UPDATE
+			SET nickname = $2, expires = $3
+		`,
+		oldNickname,
+		res.Nickname,
+		redirectUntil,
+	); err != nil {
+		return models.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+	return res, nil
+}
+
+func (p Postgres) GetUserByRedirect(oldNickname string) (models.User, error) {
+	query := `SELECT u.nickname, u.fullname, u.email, u.about, u.version FROM user_redirects r
+		JOIN users u ON u.nickname = r.nickname
+		WHERE r.old_nickname = $1 AND r.expires > NOW()`
+	user := models.User{}
+	err := p.DB.Get(&user, query, oldNickname)
+	return user, err
+}
+
+// GetUsersByRedirects resolves the live redirects among oldNicknames, keyed by
+// the lowercased old nickname.
+func (p Postgres) GetUsersByRedirects(oldNicknames []string) (map[string]models.User, error) {
+	var rows []struct {
+		OldNickname string `db:"old_nickname"`
+		models.User
+	}
+	query := `SELECT r.old_nickname, u.nickname, u.fullname, u.email, u.about, u.version FROM user_redirects r
+		JOIN users u ON u.nickname = r.nickname
+		WHERE r.old_nickname = ANY($1::citext[]) AND r.expires > NOW()`
+	if err := p.DB.Select(&rows, query, pq.Array(oldNicknames)); err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]models.User, len(rows))
+	for _, row := range rows {
+		users[strings.ToLower(row.OldNickname)] = row.User
+	}
+	return users, nil
+}
+
+// ExportUser walks everything stored about a user and hands it to emit one
+// record at a time, grouped by record type. The reads share one snapshot so
+// the export is consistent even while the user keeps posting.
+func (p Postgres) ExportUser(nickname string, emit func(models.ExportRecord) error) error {
+	tx, err := p.DB.BeginTxx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user := models.User{}
+	err = tx.Get(&user, `SELECT nickname, fullname, email, about FROM users WHERE nickname = $1`, nickname)
+	if err != nil {
+		return err
+	}
+	if err = emit(models.ExportRecord{Type: models.ExportProfile, Data: user}); err != nil {
+		return err
+	}
+
+	rows, err := tx.Queryx(
+		`
+			SELECT t.id, COALESCE(t.slug, ''), t.author, t.forum, t.title, t.message, t.votes, t.created, f.title
+			FROM threads t
+			JOIN forums f ON f.slug = t.forum
+			WHERE t.author = $1
+			ORDER BY t.id
+		`,
+		nickname,
+	)
+	if err != nil {
+		return err
+	}
+	err = eachRow(rows, func() error {
+		var th models.ExportThreadData
+		if err := rows.Scan(&th.ID, &th.Slug, &th.Author, &th.Forum, &th.Title, &th.Message, &th.Votes, &th.Created, &th.ForumTitle); err != nil {
+			return err
+		}
+		return emit(models.ExportRecord{Type: models.ExportThread, Data: th})
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = tx.Queryx(
+		`
+			SELECT p.id, p.author, p.created, p.forum, p.is_edited, p.message, p.parent, p.thread, p.path, f.title, t.title, COALESCE(t.slug, '')
+			FROM posts p
+			JOIN forums f ON f.slug = p.forum
+			JOIN threads t ON t.id = p.thread
+			WHERE p.author = $1
+			ORDER BY p.id
+		`,
+		nickname,
+	)
+	if err != nil {
+		return err
+	}
+	err = eachRow(rows, func() error {
+		var post models.ExportPostData
+		var path []int64
+		if err := rows.Scan(&post.ID, &post.Author, &post.Created, &post.Forum, &post.IsEdited, &post.Message, &post.Parent, &post.ThreadID, pq.Array(&path), &post.ForumTitle, &post.ThreadTitle, &post.ThreadSlug); err != nil {
+			return err
+		}
+		for _, id := range path {
+			post.Path = append(post.Path, uint64(id))
+		}
+		return emit(models.ExportRecord{Type: models.ExportPost, Data: post})
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = tx.Queryx(
+		`
+			SELECT v.thread, t.title, t.forum, v.voice
+			FROM votes v
+			JOIN threads t ON t.id = v.thread
+			WHERE v.nickname = $1
+			ORDER BY v.thread
+		`,
+		nickname,
+	)
+	if err != nil {
+		return err
+	}
+	err = eachRow(rows, func() error {
+		var vote models.ExportVoteData
+		if err := rows.Scan(&vote.ThreadID, &vote.ThreadTitle, &vote.Forum, &vote.VoiceValue); err != nil {
+			return err
+		}
+		return emit(models.ExportRecord{Type: models.ExportVote, Data: vote})
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = tx.Queryx(
+		`
+			SELECT f.slug, f.title, f.user_nick, f.posts, f.threads
+			FROM forum_users fu
+			JOIN forums f ON f.slug = fu.forum
+			WHERE fu.user_nick = $1
+			ORDER BY f.slug
+		`,
+		nickname,
+	)
+	if err != nil {
+		return err
+	}
+	return eachRow(rows, func() error {
+		var forum models.Forum
+		if err := rows.StructScan(&forum); err != nil {
+			return err
+		}
+		return emit(models.ExportRecord{Type: models.ExportForum, Data: forum})
+	})
+}
+
+func eachRow(rows *sqlx.Rows, fn func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (p Postgres) GetUsersByEmailsNicknames(emails, nicknames []string) ([]models.User, error) {
+	query := `SELECT nickname, fullname, email, about FROM users WHERE email = ANY($1::citext[]) OR nickname = ANY($2::citext[])`
+	users := []models.User{}
+	err := p.DB.Select(&users, query, pq.Array(emails), pq.Array(nicknames))
+	return users, err
+}
+
+// CreateUsers inserts a batch in one transaction and returns the nicknames that
+// were actually stored. Rows that lost a race on a unique key are skipped rather
+// than failing the batch; conflicts maps their lowercased nicknames to
+// ErrConflictNickname or ErrConflictEmail. With dryRun the transaction is
+// rolled back.
+func (p Postgres) CreateUsers(users []models.User, dryRun bool) ([]string, map[string]error, error) {
+	nicknames := make([]string, 0, len(users))
+	fullnames := make([]string, 0, len(users))
+	emails := make([]string, 0, len(users))
+	abouts := make([]string, 0, len(users))
+	for _, user := range users {
+		nicknames = append(nicknames, user.Nickname)
+		fullnames = append(fullnames, user.FullName)
+		emails = append(emails, user.Email)
+		abouts = append(abouts, user.About)
+	}
+
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	inserted := []string{}
+	err = tx.Select(
+		&inserted,
+		`
+			INSERT INTO users (nickname, fullname, email, about)
+			SELECT * FROM unnest($1::citext[], $2::varchar[], $3::citext[], $4::varchar[])
+			ON CONFLICT DO NOTHING
+			RETURNING nickname
+		`,
+		pq.Array(nicknames),
+		pq.Array(fullnames),
+		pq.Array(emails),
+		pq.Array(abouts),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// ON CONFLICT doesn't say which key clashed, so the skipped rows, rare
+	// as they are, are retried one by one for the constraint name.
+	stored := make(map[string]bool, len(inserted))
+	for _, nickname := range inserted {
+		stored[strings.ToLower(nickname)] = true
+	}
+	conflicts := map[string]error{}
+	for _, user := range users {
+		if stored[strings.ToLower(user.Nickname)] {
+			continue
+		}
+		if _, err = tx.Exec(`SAVEPOINT import_row`); err != nil {
+			return nil, nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO users (nickname, fullname, email, about) VALUES ($1, $2, $3, $4)`,
+			user.Nickname, user.FullName, user.Email, user.About)
+		if err == nil {
+			inserted = append(inserted, user.Nickname)
+			continue
+		}
+		pgErr, ok := err.(*pq.Error)
+		if !ok || pgErr.Code != "23505" {
+			return nil, nil, err
+		}
+		if pgErr.Constraint == "users_email_key" {
+			conflicts[strings.ToLower(user.Nickname)] = e.ErrConflictEmail
+		} else {
+			conflicts[strings.ToLower(user.Nickname)] = e.ErrConflictNickname
+		}
+		if _, err = tx.Exec(`ROLLBACK TO SAVEPOINT import_row`); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if dryRun {
+		return inserted, conflicts, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return inserted, conflicts, nil
+}
+
+func (p Postgres) GetUserStats(nickname string) (models.UserStats, error) {
+	query := `SELECT threads, posts, forums, karma FROM user_stats WHERE nickname = $1`
+	stats := models.UserStats{}
+	err := p.DB.Get(&stats, query, nickname)
+	return stats, err
+}
+
+func (p Postgres) FollowUser(follower, followee string) error {
+	query := `INSERT INTO user_follows (follower, followee) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := p.DB.Exec(query, follower, followee)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return e.ErrUserNotFound
+		}
+	}
+	return err
+}
+
+func (p Postgres) UnfollowUser(follower, followee string) error {
+	query := `DELETE FROM user_follows WHERE follower = $1 AND followee = $2`
+	_, err := p.DB.Exec(query, follower, followee)
+	return err
+}
+
+func (p Postgres) FollowForum(follower, forum string) error {
+	query := `INSERT INTO forum_follows (follower, forum) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := p.DB.Exec(query, follower, forum)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			if pgErr.Constraint == "forum_follows_forum_fkey" {
+				return e.ErrForumNotFound
+			}
+			return e.ErrUserNotFound
+		}
+	}
+	return err
+}
+
+func (p Postgres) UnfollowForum(follower, forum string) error {
+	query := `DELETE FROM forum_follows WHERE follower = $1 AND forum = $2`
+	_, err := p.DB.Exec(query, follower, forum)
+	return err
+}
+
+func (p Postgres) GetFollowing(nickname string) (models.Following, error) {
+	following := models.Following{Users: []string{}, Forums: []string{}}
+
+	err := p.DB.Select(&following.Users, `SELECT followee FROM user_follows WHERE follower = $1 ORDER BY followee`, nickname)
+	if err != nil {
+		return models.Following{}, err
+	}
+
+	err = p.DB.Select(&following.Forums, `SELECT forum FROM forum_follows WHERE follower = $1 ORDER BY forum`, nickname)
+	if err != nil {
+		return models.Following{}, err
+	}
+	return following, nil
+}
+
+// GetFeed merges threads and posts from followed users and forums, newest
+// first. Every followed source contributes at most limit rows past the cursor,
+// so the merge never touches more than (sources * limit) rows.
+func (p Postgres) GetFeed(nickname string, cursor models.FeedCursor, limit uint64) ([]models.FeedItem, error) {
+	inner := ``
+	outer := ``
+	args := []interface{}{nickname, limit}
+	if cursor.Kind != "" {
+		inner = ` AND (x.created, x.id) <= ($3, $4)`
+		outer = ` WHERE (feed.created, feed.id, feed.kind) < ($3, $4, $5)`
+		args = append(args, cursor.Created, cursor.ID, cursor.Kind)
+	}
+
+	threads := `SELECT 'thread' AS kind, x.id, x.id AS thread, x.author, x.forum, x.title, x.message, x.created FROM threads x`
+	posts := `SELECT 'post' AS kind, x.id, x.thread, x.author, x.forum, '' AS title, x.message, x.created FROM posts x`
+	order := ` ORDER BY x.created DESC, x.id DESC LIMIT $2`
+	// Content of members-only forums reaches only their members, staff and admins.
+	inner += ` AND EXISTS (SELECT 1 FROM forums v WHERE v.slug = x.forum AND ` + policy.ReadableBy("v", "$1") + `)`
+
+	query := `
+		SELECT DISTINCT feed.kind, feed.id, feed.thread, feed.author, feed.forum, feed.title, feed.message, feed.created FROM (
+			SELECT s.* FROM forum_follows f CROSS JOIN LATERAL (` + threads + ` WHERE x.forum = f.forum` + inner + order + `) s WHERE f.follower = $1
+			UNION ALL
+			SELECT s.* FROM user_follows f CROSS JOIN LATERAL (` + threads + ` WHERE x.author = f.followee` + inner + order + `) s WHERE f.follower = $1
+			UNION ALL
+			SELECT s.* FROM forum_follows f CROSS JOIN LATERAL (` + posts + ` WHERE x.forum = f.forum` + inner + order + `) s WHERE f.follower = $1
+			UNION ALL
+			SELECT s.* FROM user_follows f CROSS JOIN LATERAL (` + posts + ` WHERE x.author = f.followee` + inner + order + `) s WHERE f.follower = $1
+		) feed` + outer + `
+		ORDER BY feed.created DESC, feed.id DESC, feed.kind DESC
+		LIMIT $2
+	`
+
+	items := make([]models.FeedItem, 0)
+	err := p.DB.Select(&items, query, args...)
+	return items, err
+}
+
+func (p Postgres) AddRelation(nickname, target, kind string) error {
+	query := `INSERT INTO user_relations (nickname, target, kind) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	_, err := p.DB.Exec(query, nickname, target, kind)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return e.ErrUserNotFound
+		}
+	}
+	return err
+}
+
+func (p Postgres) RemoveRelation(nickname, target, kind string) error {
+	query := `DELETE FROM user_relations WHERE nickname = $1 AND target = $2 AND kind = $3`
+	_, err := p.DB.Exec(query, nickname, target, kind)
+	return err
+}
+
+func (p Postgres) GetRelations(nickname string) (models.UserRelations, error) {
+	relations := models.UserRelations{Muted: []string{}, Blocked: []string{}}
+	query := `SELECT target FROM user_relations WHERE nickname = $1 AND kind = $2 ORDER BY target`
+
+	if err := p.DB.Select(&relations.Muted, query, nickname, models.RelationMute); err != nil {
+		return models.UserRelations{}, err
+	}
+	if err := p.DB.Select(&relations.Blocked, query, nickname, models.RelationBlock); err != nil {
+		return models.UserRelations{}, err
+	}
+	return relations, nil
+}
+
+// IsBlocked tells whether any blockers[i] blocked nicknames[i], so a batch of
+// replies is checked in one query.
+func (p Postgres) IsBlocked(blockers, nicknames []string) (bool, error) {
+	if len(blockers) == 0 {
+		return false, nil
+	}
+	query := `SELECT EXISTS (
+		SELECT 1 FROM unnest($1::citext[], $2::citext[]) AS pair(blocker, nickname)
+		JOIN user_relations ON user_relations.nickname = pair.blocker AND user_relations.target = pair.nickname AND user_relations.kind = 'block')`
+	var blocked bool
+	err := p.DB.Get(&blocked, query, pq.Array(blockers), pq.Array(nicknames))
+	return blocked, err
+}
+
+func (p Postgres) GetUserRole(nickname string) (string, error) {
+	var role string
+	err := p.DB.Get(&role, `SELECT role FROM users WHERE nickname = $1`, nickname)
+	return role, err
+}
+
+// SetUserRole changes the global role and records who did it; an empty
+// actor is a trusted caller such as forumctl.
+func (p Postgres) SetUserRole(nickname, role, actor string) error {
+	tx, err := p.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET role = $1 WHERE nickname = $2`, role, nickname)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	details := models.UserRole{Nickname: nickname, Role: role}
+	if err = audit.Record(tx, actor, "user.role", nickname, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
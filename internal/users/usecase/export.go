@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
+	e "technopark_db_forum/pkg/errors"
+)
+
+// exportFlushEvery controls how often buffered export output is pushed to the
+// client, so large accounts stream instead of piling up in memory.
+const exportFlushEvery = 100
+
+// flusher is implemented by writers that buffer output, such as an HTTP
+// response; the usecase only needs to know how to push it out.
+type flusher interface {
+	Flush()
+}
+
+type exportEncoder interface {
+	Encode(record models.ExportRecord) error
+	Flush() error
+	Close() error
+}
+
+func newExportEncoder(format string, w io.Writer) (exportEncoder, error) {
+	switch format {
+	case models.ExportFormatNDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	case models.ExportFormatZIP:
+		return &zipEncoder{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, e.ErrInvalidFormat
+	}
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonEncoder) Encode(record models.ExportRecord) error {
+	return n.enc.Encode(record)
+}
+
+func (n *ndjsonEncoder) Flush() error {
+	return nil
+}
+
+func (n *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// zipEncoder writes one <type>.ndjson entry per record type. Records arrive
+// grouped by type, so a new entry is opened whenever the type changes.
+type zipEncoder struct {
+	zw      *zip.Writer
+	current string
+	enc     *json.Encoder
+}
+
+func (z *zipEncoder) Encode(record models.ExportRecord) error {
+	if record.Type != z.current {
+		w, err := z.zw.Create(record.Type + "s.ndjson")
+		if err != nil {
+			return err
+		}
+		z.current = record.Type
+		z.enc = json.NewEncoder(w)
+	}
+	return z.enc.Encode(record.Data)
+}
+
+func (z *zipEncoder) Flush() error {
+	return z.zw.Flush()
+}
+
+func (z *zipEncoder) Close() error {
+	return z.zw.Close()
+}
+
+// ExportUser streams a user's data on behalf of actor, who must be the user
+// themself or an admin.
+func (u usecase) ExportUser(nickname, format, actor string, w io.Writer) error {
+	if err := u.policy.Authorize(actor, models.Forum{}, policy.ExportAccount, nickname); err != nil {
+		return err
+	}
+	return u.DumpUser(nickname, format, w)
+}
+
+// DumpUser streams a user's data without an acting user. It is meant for
+// trusted callers such as forumctl.
+func (u usecase) DumpUser(nickname, format string, w io.Writer) error {
+	enc, err := newExportEncoder(format, w)
+	if err != nil {
+		return err
+	}
+
+	written := 0
+	err = u.userRepository.ExportUser(nickname, func(record models.ExportRecord) error {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			return flush(enc, w)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = enc.Close(); err != nil {
+		return err
+	}
+	flushWriter(w)
+	return nil
+}
+
+func flush(enc exportEncoder, w io.Writer) error {
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	flushWriter(w)
+	return nil
+}
+
+func flushWriter(w io.Writer) {
+	if f, ok := w.(flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+	"time"
+)
+
+// Feed cursors are opaque to clients: base64 of "<unix nanos>:<id>:<kind>".
+func encodeFeedCursor(item models.FeedItem) string {
+	raw := fmt.Sprintf("%d:%d:%s", item.Created.UnixNano(), item.ID, item.Kind)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeFeedCursor(cursor string) (models.FeedCursor, error) {
+	if cursor == "" {
+		return models.FeedCursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return models.FeedCursor{}, e.ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || (parts[2] != models.FeedKindThread && parts[2] != models.FeedKindPost) {
+		return models.FeedCursor{}, e.ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return models.FeedCursor{}, e.ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return models.FeedCursor{}, e.ErrInvalidCursor
+	}
+	return models.FeedCursor{Created: time.Unix(0, nanos), ID: id, Kind: parts[2]}, nil
+}
+
+func (u usecase) Follow(nickname string, follow models.Follow) error {
+	if (follow.User == "") == (follow.Forum == "") || strings.EqualFold(follow.User, nickname) {
+		return e.ErrInvalidFollow
+	}
+	if _, err := u.userRepository.GetUserByNickname(nickname); err != nil {
+		return err
+	}
+
+	if follow.User != "" {
+		return u.userRepository.FollowUser(nickname, follow.User)
+	}
+	return u.userRepository.FollowForum(nickname, follow.Forum)
+}
+
+func (u usecase) Unfollow(nickname string, follow models.Follow) error {
+	if (follow.User == "") == (follow.Forum == "") {
+		return e.ErrInvalidFollow
+	}
+	if follow.User != "" {
+		return u.userRepository.UnfollowUser(nickname, follow.User)
+	}
+	return u.userRepository.UnfollowForum(nickname, follow.Forum)
+}
+
+func (u usecase) GetFollowing(nickname string) (models.Following, error) {
+	if _, err := u.userRepository.GetUserByNickname(nickname); err != nil {
+		return models.Following{}, err
+	}
+
+	following, err := u.userRepository.GetFollowing(nickname)
+	if err != nil {
+		return models.Following{}, err
+	}
+	return following, nil
+}
+
+func (u usecase) GetFeed(nickname, cursor string, limit uint64) (models.FeedPage, error) {
+	since, err := decodeFeedCursor(cursor)
+	if err != nil {
+		return models.FeedPage{}, err
+	}
+
+	if _, err = u.userRepository.GetUserByNickname(nickname); err != nil {
+		return models.FeedPage{}, err
+	}
+
+	items, err := u.userRepository.GetFeed(nickname, since, limit)
+	if err != nil {
+		return models.FeedPage{}, err
+	}
+
+	page := models.FeedPage{Items: items}
+	if uint64(len(items)) == limit && len(items) != 0 {
+		page.Next = encodeFeedCursor(items[len(items)-1])
+	}
+	return page, nil
+}
@@ -0,0 +1,288 @@
+package usecase
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+)
+
+// importBatchSize is the number of rows loaded per transaction.
+const importBatchSize = 1000
+
+var nicknamePattern = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+type importRow struct {
+	line    int
+	user    models.User
+	invalid string
+}
+
+// importReader yields rows one by one; ok is false once the input is drained.
+type importReader func() (row importRow, ok bool, err error)
+
+func newImportReader(format string, r io.Reader) (importReader, error) {
+	switch format {
+	case models.ImportFormatCSV:
+		return newCSVReader(r)
+	case models.ImportFormatNDJSON:
+		return newNDJSONReader(r), nil
+	default:
+		return nil, e.ErrInvalidFormat
+	}
+}
+
+func newCSVReader(r io.Reader) (importReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return func() (importRow, bool, error) { return importRow{}, false, nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"nickname", "email"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: csv header has no %q column", e.ErrInvalidFormat, name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	return func() (importRow, bool, error) {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return importRow{}, false, nil
+		}
+		if pe, ok := err.(*csv.ParseError); ok {
+			// A malformed record is reported, not fatal for the whole import.
+			return importRow{line: pe.StartLine, invalid: pe.Err.Error()}, true, nil
+		}
+		if err != nil {
+			return importRow{}, false, err
+		}
+		line, _ := cr.FieldPos(0)
+		return importRow{
+			line: line,
+			user: models.User{
+				Nickname: field(record, "nickname"),
+				FullName: field(record, "fullname"),
+				Email:    field(record, "email"),
+				About:    field(record, "about"),
+			},
+		}, true, nil
+	}, nil
+}
+
+func newNDJSONReader(r io.Reader) importReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+
+	return func() (importRow, bool, error) {
+		for sc.Scan() {
+			line++
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			var user models.User
+			if err := json.Unmarshal([]byte(text), &user); err != nil {
+				// A broken line is reported, not fatal for the whole import.
+				return importRow{line: line, invalid: err.Error()}, true, nil
+			}
+			return importRow{line: line, user: user}, true, nil
+		}
+		return importRow{}, false, sc.Err()
+	}
+}
+
+func validateImportUser(user models.User) string {
+	switch {
+	case !nicknamePattern.MatchString(user.Nickname):
+		return "nickname must be non-empty and contain only letters, digits, '_' and '.'"
+	case user.Email == "" || !strings.Contains(user.Email, "@"):
+		return "email is missing or malformed"
+	case user.FullName == "":
+		return "fullname is required"
+	}
+	return ""
+}
+
+// importState remembers keys already seen in this import, so duplicates
+// across batches are caught even when a dry run never commits them.
+type importState struct {
+	nicknames map[string]models.User
+	emails    map[string]models.User
+	result    models.UserImportResult
+}
+
+func (s *importState) reject(row importRow, status, reason string, conflicts []models.User) {
+	s.result.Rejected++
+	s.result.Rows = append(s.result.Rows, models.UserImportRow{
+		Line:      row.line,
+		Nickname:  row.user.Nickname,
+		Status:    status,
+		Error:     reason,
+		Conflicts: conflicts,
+	})
+}
+
+// ImportUsers loads users in batches. Batches are committed as they go, so on
+// an error the result still reports every row handled before it.
+func (u usecase) ImportUsers(r io.Reader, format string, dryRun bool) (models.UserImportResult, error) {
+	next, err := newImportReader(format, r)
+	if err != nil {
+		return models.UserImportResult{}, err
+	}
+
+	state := &importState{
+		nicknames: map[string]models.User{},
+		emails:    map[string]models.User{},
+		result:    models.UserImportResult{DryRun: dryRun, Rows: []models.UserImportRow{}},
+	}
+
+	batch := make([]importRow, 0, importBatchSize)
+	for {
+		row, ok, err := next()
+		if err != nil {
+			return state.result, err
+		}
+		if !ok {
+			break
+		}
+
+		state.result.Total++
+		if row.invalid == "" {
+			row.invalid = validateImportUser(row.user)
+		}
+		if reason := row.invalid; reason != "" {
+			state.reject(row, models.ImportStatusInvalid, reason, nil)
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) == importBatchSize {
+			if err = u.importBatch(state, batch, dryRun); err != nil {
+				return state.result, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) != 0 {
+		if err = u.importBatch(state, batch, dryRun); err != nil {
+			return state.result, err
+		}
+	}
+	return state.result, nil
+}
+
+// importBatch classifies conflicts the same way CreateUser does, against
+// stored users, live nickname redirects and earlier rows of the import, and
+// loads the remaining rows.
+func (u usecase) importBatch(state *importState, batch []importRow, dryRun bool) error {
+	emails := make([]string, 0, len(batch))
+	nicknames := make([]string, 0, len(batch))
+	for _, row := range batch {
+		emails = append(emails, row.user.Email)
+		nicknames = append(nicknames, row.user.Nickname)
+	}
+
+	existing, err := u.userRepository.GetUsersByEmailsNicknames(emails, nicknames)
+	if err != nil {
+		return err
+	}
+	byNickname, err := u.userRepository.GetUsersByRedirects(nicknames)
+	if err != nil {
+		return err
+	}
+	byEmail := map[string]models.User{}
+	for _, user := range existing {
+		byNickname[strings.ToLower(user.Nickname)] = user
+		byEmail[strings.ToLower(user.Email)] = user
+	}
+
+	candidates := make([]importRow, 0, len(batch))
+	users := make([]models.User, 0, len(batch))
+	for _, row := range batch {
+		nickname := strings.ToLower(row.user.Nickname)
+		email := strings.ToLower(row.user.Email)
+
+		var conflicts []models.User
+		status := ""
+		for _, seen := range []map[string]models.User{byNickname, state.nicknames} {
+			if user, ok := seen[nickname]; ok {
+				conflicts = append(conflicts, user)
+				status = models.ImportStatusConflictNickname
+				break
+			}
+		}
+		for _, seen := range []map[string]models.User{byEmail, state.emails} {
+			if user, ok := seen[email]; ok {
+				if len(conflicts) == 0 || !strings.EqualFold(conflicts[0].Nickname, user.Nickname) {
+					conflicts = append(conflicts, user)
+				}
+				if status == "" {
+					status = models.ImportStatusConflictEmail
+				}
+				break
+			}
+		}
+		if status != "" {
+			state.reject(row, status, "", conflicts)
+			continue
+		}
+
+		state.nicknames[nickname] = row.user
+		state.emails[email] = row.user
+		candidates = append(candidates, row)
+		users = append(users, row.user)
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+
+	inserted, conflicts, err := u.userRepository.CreateUsers(users, dryRun)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(inserted))
+	for _, nickname := range inserted {
+		stored[strings.ToLower(nickname)] = true
+	}
+
+	for _, row := range candidates {
+		if stored[strings.ToLower(row.user.Nickname)] {
+			state.result.Imported++
+			continue
+		}
+		// Somebody registered the same nickname or email between the lookup
+		// and the insert.
+		status := models.ImportStatusConflictNickname
+		if conflicts[strings.ToLower(row.user.Nickname)] == e.ErrConflictEmail {
+			status = models.ImportStatusConflictEmail
+		}
+		state.reject(row, status, "created concurrently", nil)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package usecase
+
+import (
+	"strings"
+	"technopark_db_forum/internal/models"
+	e "technopark_db_forum/pkg/errors"
+)
+
+func (u usecase) SetRelation(nickname, target, kind string, on bool) (models.UserRelations, error) {
+	if target == "" || strings.EqualFold(target, nickname) {
+		return models.UserRelations{}, e.ErrInvalidRelation
+	}
+	if _, err := u.userRepository.GetUserByNickname(nickname); err != nil {
+		return models.UserRelations{}, err
+	}
+
+	var err error
+	if on {
+		err = u.userRepository.AddRelation(nickname, target, kind)
+	} else {
+		err = u.userRepository.RemoveRelation(nickname, target, kind)
+	}
+	if err != nil {
+		return models.UserRelations{}, err
+	}
+	return u.userRepository.GetRelations(nickname)
+}
+
+func (u usecase) GetRelations(nickname string) (models.UserRelations, error) {
+	if _, err := u.userRepository.GetUserByNickname(nickname); err != nil {
+		return models.UserRelations{}, err
+	}
+	return u.userRepository.GetRelations(nickname)
+}
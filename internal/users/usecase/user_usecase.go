@@ -3,9 +3,12 @@ package usecase
 import (
 	"database/sql"
 	"errors"
+	"io"
 	"technopark_db_forum/internal/models"
+	"technopark_db_forum/internal/policy"
 	userRepository "technopark_db_forum/internal/users/repository"
 	e "technopark_db_forum/pkg/errors"
+	"time"
 
 	"github.com/jinzhu/copier"
 )
@@ -15,16 +18,39 @@ type UsersUsecase interface {
 	GetUsersByEmailNickname(email, nickname string) ([]models.User, error)
 	GetUserByNickname(nickname string) (models.User, error)
 	GetUserByEmail(email string) (models.User, error)
-	UpdateUser(user models.User) (models.User, error)
+	UpdateUser(user models.User, version uint64) (models.User, error)
+	RenameUser(oldNickname, newNickname string) (models.User, error)
+	GetUserProfile(nickname string) (models.User, error)
+	ExportUser(nickname, format, actor string, w io.Writer) error
+	DumpUser(nickname, format string, w io.Writer) error
+	ImportUsers(r io.Reader, format string, dryRun bool) (models.UserImportResult, error)
+	GetUserStats(nickname string) (models.UserStats, error)
+
+	Follow(nickname string, follow models.Follow) error
+	Unfollow(nickname string, follow models.Follow) error
+	GetFollowing(nickname string) (models.Following, error)
+	GetFeed(nickname, cursor string, limit uint64) (models.FeedPage, error)
+
+	SetRelation(nickname, target, kind string, on bool) (models.UserRelations, error)
+	GetRelations(nickname string) (models.UserRelations, error)
+
+	SetRole(nickname, role, actor string) (models.UserRole, error)
+	GrantRole(nickname, role string) (models.UserRole, error)
 }
 
+// nicknameRedirectTTL is the grace period during which an old nickname keeps
+// answering with the renamed user.
+const nicknameRedirectTTL = 30 * 24 * time.Hour
+
 type usecase struct {
 	userRepository userRepository.UserRepository
+	policy         policy.Enforcer
 }
 
 func NewUserUsecase(userRepo userRepository.UserRepository) UsersUsecase {
 	return &usecase{
 		userRepository: userRepo,
+		policy:         policy.NewEnforcer(userRepo, nil),
 	}
 }
 
@@ -47,6 +73,16 @@ func (u usecase) CreateUser(user models.User) ([]models.User, error) {
 		return users, e.ErrDuplicate
 	}
 
+	// A live redirect still answers for the nickname, as in RenameUser, so
+	// its owner is the conflicting user.
+	owner, err := u.userRepository.GetUserByRedirect(user.Nickname)
+	if err == nil {
+		return []models.User{owner}, e.ErrDuplicate
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return []models.User{}, err
+	}
+
 	res, err := u.userRepository.CreateUser(user)
 	if err != nil {
 		return []models.User{}, err
@@ -70,19 +106,103 @@ func (u usecase) GetUserByEmail(email string) (models.User, error) {
 	return user, nil
 }
 
-func (u usecase) UpdateUser(user models.User) (models.User, error) {
+// UpdateUser applies the non-empty fields of user. A non-zero version is the
+// one the client last saw (If-Match); a mismatch is ErrPreconditionFailed.
+func (u usecase) UpdateUser(user models.User, version uint64) (models.User, error) {
 	us, err := u.userRepository.GetUserByNickname(user.Nickname)
 	if err != nil {
 		return models.User{}, err
 	}
+	if version != 0 && us.Version != version {
+		return us, e.ErrPreconditionFailed
+	}
 
+	current := us
 	if err = copier.CopyWithOption(&us, &user, copier.Option{IgnoreEmpty: true}); err != nil {
 		return models.User{}, err
 	}
-	
-	res, err := u.userRepository.UpdateUser(us)
+	if us == current {
+		return current, nil
+	}
+
+	res, err := u.userRepository.UpdateUser(us, version)
+	if err != nil {
+		if err == sql.ErrNoRows && version != 0 {
+			return models.User{}, e.ErrPreconditionFailed
+		}
+		return models.User{}, err
+	}
+	return res, nil
+}
+
+func (u usecase) RenameUser(oldNickname, newNickname string) (models.User, error) {
+	if newNickname == "" {
+		return models.User{}, e.ErrInvalidNickname
+	}
+
+	if _, err := u.userRepository.GetUserByNickname(oldNickname); err != nil {
+		return models.User{}, err
+	}
+
+	res, err := u.userRepository.RenameUser(oldNickname, newNickname, time.Now().Add(nicknameRedirectTTL))
 	if err != nil {
 		return models.User{}, err
 	}
 	return res, nil
 }
+
+func (u usecase) GetUserProfile(nickname string) (models.User, error) {
+	user, err := u.userRepository.GetUserByNickname(nickname)
+	if err == nil {
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return models.User{}, err
+	}
+
+	user, err = u.userRepository.GetUserByRedirect(nickname)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (u usecase) GetUserStats(nickname string) (models.UserStats, error) {
+	stats, err := u.userRepository.GetUserStats(nickname)
+	if err != nil {
+		return models.UserStats{}, err
+	}
+	return stats, nil
+}
+
+// SetRole changes a user's global role on behalf of an admin.
+func (u usecase) SetRole(nickname, role, actor string) (models.UserRole, error) {
+	if role != models.RoleAdmin && role != models.RoleUser {
+		return models.UserRole{}, e.ErrInvalidRole
+	}
+	if err := u.policy.Authorize(actor, models.Forum{}, policy.ManageRoles, ""); err != nil {
+		return models.UserRole{}, err
+	}
+	return u.setRole(nickname, role, actor)
+}
+
+// GrantRole changes a user's global role without an acting user. It is meant
+// for trusted callers such as forumctl, which creates the first admin.
+func (u usecase) GrantRole(nickname, role string) (models.UserRole, error) {
+	return u.setRole(nickname, role, "")
+}
+
+func (u usecase) setRole(nickname, role, actor string) (models.UserRole, error) {
+	if role != models.RoleAdmin && role != models.RoleUser {
+		return models.UserRole{}, e.ErrInvalidRole
+	}
+
+	user, err := u.userRepository.GetUserByNickname(nickname)
+	if err != nil {
+		return models.UserRole{}, err
+	}
+	if err = u.userRepository.SetUserRole(user.Nickname, role, actor); err != nil {
+		return models.UserRole{}, err
+	}
+	return models.UserRole{Nickname: user.Nickname, Role: role}, nil
+}
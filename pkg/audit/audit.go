@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Record writes an audit_log entry through exec, normally the transaction of
+// the change being recorded. details is stored as JSON.
+func Record(exec sqlx.Execer, actor, action, target string, details interface{}) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO audit_log (actor, action, target, details) VALUES (NULLIF($1, '')::citext, $2, $3, $4)`
+	_, err = exec.Exec(query, actor, action, target, payload)
+	return err
+}
@@ -0,0 +1,260 @@
+// Package diff renders line based unified diffs, as diff -u does, using
+// Myers' shortest edit script in linear space.
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// Context is the number of unchanged lines shown around each change.
+	Context = 3
+	// MaxLines caps the lines of both sides searched for a shortest edit
+	// script; longer inputs are diffed as one replacement.
+	MaxLines = 10000
+)
+
+type op int
+
+const (
+	equal op = iota
+	del
+	ins
+)
+
+type edit struct {
+	op   op
+	text string
+}
+
+// Unified diffs a against b. It returns "" when they are equal.
+func Unified(fromName, toName, a, b string) string {
+	edits := script(split(a), split(b))
+
+	// aPos and bPos are the line offsets in a and b before each edit.
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	var hunks [][2]int
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.op != ins {
+			aPos[i+1]++
+		}
+		if e.op != del {
+			bPos[i+1]++
+		}
+		if e.op == equal {
+			continue
+		}
+
+		lo, hi := i-Context, i+Context+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(edits) {
+			hi = len(edits)
+		}
+		if n := len(hunks); n > 0 && lo <= hunks[n-1][1] {
+			hunks[n-1][1] = hi
+		} else {
+			hunks = append(hunks, [2]int{lo, hi})
+		}
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks {
+		lo, hi := h[0], h[1]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", span(aPos[lo], aPos[hi]-aPos[lo]), span(bPos[lo], bPos[hi]-bPos[lo]))
+		for _, e := range edits[lo:hi] {
+			switch e.op {
+			case equal:
+				out.WriteByte(' ')
+			case del:
+				out.WriteByte('-')
+			case ins:
+				out.WriteByte('+')
+			}
+			out.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return out.String()
+}
+
+// span formats a hunk range; an empty one names the line before it.
+func span(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// split cuts s into lines that keep their "\n", so a missing newline at the
+// end of s is a difference too.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// script returns the edits turning a into b. Inputs longer than MaxLines
+// together are not searched: all of a is replaced by all of b.
+func script(a, b []string) []edit {
+	if len(a)+len(b) > MaxLines {
+		edits := make([]edit, 0, len(a)+len(b))
+		for _, line := range a {
+			edits = append(edits, edit{del, line})
+		}
+		for _, line := range b {
+			edits = append(edits, edit{ins, line})
+		}
+		return edits
+	}
+
+	s := searcher{a: a, b: b}
+	s.walk(0, 0, len(a), len(b))
+	return deletesFirst(s.edits)
+}
+
+// deletesFirst orders every run of changes as diff -u does, removed lines
+// before added ones.
+func deletesFirst(edits []edit) []edit {
+	for lo := 0; lo < len(edits); lo++ {
+		if edits[lo].op == equal {
+			continue
+		}
+		hi := lo
+		for hi < len(edits) && edits[hi].op != equal {
+			hi++
+		}
+		sort.SliceStable(edits[lo:hi], func(i, j int) bool {
+			return edits[lo+i].op == del && edits[lo+j].op == ins
+		})
+		lo = hi
+	}
+	return edits
+}
+
+// searcher runs the linear space variant of Myers' O((N+M)D) algorithm: the
+// middle snake of a box splits it in two, and each half is searched again,
+// so only the furthest reaching paths of the current round are kept.
+type searcher struct {
+	a, b  []string
+	edits []edit
+}
+
+// walk appends the edits turning a[left:right] into b[top:bottom].
+func (s *searcher) walk(left, top, right, bottom int) {
+	for left < right && top < bottom && s.a[left] == s.b[top] {
+		s.edits = append(s.edits, edit{equal, s.a[left]})
+		left++
+		top++
+	}
+	var tail []edit
+	for left < right && top < bottom && s.a[right-1] == s.b[bottom-1] {
+		tail = append(tail, edit{equal, s.a[right-1]})
+		right--
+		bottom--
+	}
+
+	switch {
+	case left == right:
+		for ; top < bottom; top++ {
+			s.edits = append(s.edits, edit{ins, s.b[top]})
+		}
+	case top == bottom:
+		for ; left < right; left++ {
+			s.edits = append(s.edits, edit{del, s.a[left]})
+		}
+	default:
+		x, y, u, v := s.middleSnake(left, top, right, bottom)
+		s.walk(left, top, x, y)
+		for ; x < u; x, y = x+1, y+1 {
+			s.edits = append(s.edits, edit{equal, s.a[x]})
+		}
+		s.walk(u, v, right, bottom)
+	}
+
+	for i := len(tail) - 1; i >= 0; i-- {
+		s.edits = append(s.edits, tail[i])
+	}
+}
+
+// middleSnake runs the search from both corners of a box that differs at
+// both ends until the paths overlap, and returns the snake (x,y)-(u,v) where
+// they met. The box is at least one edit away from either corner, so both
+// halves left around the snake are smaller than the box.
+func (s *searcher) middleSnake(left, top, right, bottom int) (x, y, u, v int) {
+	n, m := right-left, bottom-top
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+
+	// forward[k] is the furthest x on diagonal x-y = k from (left, top);
+	// backward[c] the smallest x on diagonal x-y = c+delta from (right, bottom),
+	// both relative to the box.
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	// Seeded so that round 0 starts on diagonal 0 at the corner.
+	forward[offset+1] = 0
+	backward[offset+1] = n + 1
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && s.a[left+u] == s.b[top+v] {
+				u++
+				v++
+			}
+			forward[offset+k] = u
+			if c := k - delta; odd && c >= -(d-1) && c <= d-1 && u >= backward[offset+c] {
+				return left + x, top + y, left + u, top + v
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			// Going backwards, a step left comes from diagonal c+1 and a
+			// step up from c-1; take whichever lands further left.
+			if c == -d || (c != d && backward[offset+c+1] <= backward[offset+c-1]) {
+				u = backward[offset+c+1] - 1
+			} else {
+				u = backward[offset+c-1]
+			}
+			v = u - c - delta
+			x, y = u, v
+			for x > 0 && y > 0 && s.a[left+x-1] == s.b[top+y-1] {
+				x--
+				y--
+			}
+			backward[offset+c] = x
+			if k := c + delta; !odd && k >= -d && k <= d && x <= forward[offset+k] {
+				return left + x, top + y, left + u, top + v
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
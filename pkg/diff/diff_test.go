@@ -0,0 +1,153 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "both empty",
+			want: "",
+		},
+		{
+			name: "empty old side",
+			b:    "a\nb\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "empty new side",
+			a:    "a\n",
+			want: "--- a\n+++ b\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "changed line in the middle",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\nseven\n8\n9\n10\n",
+			want: "--- a\n+++ b\n@@ -1,10 +1,11 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n+seven\n 8\n 9\n 10\n",
+		},
+		{
+			name: "distant changes get their own hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "newline added at the end",
+			a:    "x",
+			b:    "x\n",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n",
+		},
+		{
+			name: "newline removed at the end",
+			a:    "a\nx\n",
+			b:    "a\nx",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-x\n+x\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("a", "b", tt.a, tt.b); got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// lcs is the length of the longest common subsequence, by the textbook table.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestScriptIsShortest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		res := make([]string, rnd.Intn(30))
+		for i := range res {
+			res[i] = string(rune('a' + rnd.Intn(4)))
+		}
+		return res
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		edits := script(a, b)
+
+		var gotA, gotB []string
+		kept := 0
+		for _, e := range edits {
+			if e.op != ins {
+				gotA = append(gotA, e.text)
+			}
+			if e.op != del {
+				gotB = append(gotB, e.text)
+			}
+			if e.op == equal {
+				kept++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("script(%q, %q) does not rebuild its inputs", a, b)
+		}
+		if want := lcs(a, b); kept != want {
+			t.Fatalf("script(%q, %q) keeps %d lines, want %d", a, b, kept, want)
+		}
+	}
+}
+
+func TestUnifiedLargeInputs(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&a, "old %d\n", i)
+		fmt.Fprintf(&b, "new %d\n", i)
+	}
+
+	got := Unified("a", "b", a.String(), b.String())
+	if want := "--- a\n+++ b\n@@ -1,3000 +1,3000 @@\n-old 0\n"; !strings.HasPrefix(got, want) {
+		t.Fatalf("Unified() starts with %q, want %q", got[:len(want)], want)
+	}
+
+	a.Reset()
+	for i := 0; i < MaxLines; i++ {
+		a.WriteString("x\n")
+	}
+	got = Unified("a", "b", a.String(), "y\n")
+	if want := fmt.Sprintf("@@ -1,%d +1 @@\n", MaxLines); !strings.Contains(got, want) {
+		t.Fatalf("Unified() over MaxLines lacks the single replacement hunk %q", want)
+	}
+}
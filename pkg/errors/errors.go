@@ -3,19 +3,53 @@ package errors
 import "errors"
 
 var (
-	ErrDuplicate        = errors.New("duplicate")
-	ErrNoParent         = errors.New("any post has no parent")
-	ErrConflict         = errors.New("user conflict")
-	ErrConflictEmail    = errors.New("user conflict")
-	ErrConflictNickname = errors.New("user conflict")
-	ErrUserNotFound     = errors.New("user not found")
-	ErrForumNotFound    = errors.New("forum not found")
-	ErrThreadNotFound   = errors.New("thread not found")
-	ErrPostNotFound     = errors.New("post not found")
-	ErrNotFound         = errors.New("not found")
-	ErrInternal         = errors.New("internal error")
-	ErrOtherThread      = errors.New("other thread")
-	ErrNoAuthorPost     = errors.New("no author post")
-	ErrNoRowsID         = errors.New("no rows in result set")
-	ErrNoRowsSlug       = errors.New("no rows in result set")
+	ErrDuplicate           = errors.New("duplicate")
+	ErrNoParent            = errors.New("any post has no parent")
+	ErrConflict            = errors.New("user conflict")
+	ErrConflictEmail       = errors.New("user conflict")
+	ErrConflictNickname    = errors.New("user conflict")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrForumNotFound       = errors.New("forum not found")
+	ErrThreadNotFound      = errors.New("thread not found")
+	ErrPostNotFound        = errors.New("post not found")
+	ErrNotFound            = errors.New("not found")
+	ErrInternal            = errors.New("internal error")
+	ErrOtherThread         = errors.New("other thread")
+	ErrNoAuthorPost        = errors.New("no author post")
+	ErrNoRowsID            = errors.New("no rows in result set")
+	ErrNoRowsSlug          = errors.New("no rows in result set")
+	ErrInvalidNickname     = errors.New("invalid nickname")
+	ErrInvalidFormat       = errors.New("invalid format")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidFollow       = errors.New("invalid follow")
+	ErrInvalidRelation     = errors.New("invalid relation")
+	ErrBlocked             = errors.New("blocked by parent author")
+	ErrActorRequired       = errors.New("actor required")
+	ErrForbidden           = errors.New("forbidden")
+	ErrBanned              = errors.New("banned from forum")
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with another payload")
+	ErrIdempotencyInFlight = errors.New("idempotency key in use")
+	ErrForumArchived       = errors.New("forum archived")
+	ErrParentNotFound      = errors.New("parent forum not found")
+	ErrForumCycle          = errors.New("forum would become its own ancestor")
+	ErrInvalidSettings     = errors.New("invalid forum settings")
+	ErrMembersOnly         = errors.New("forum is visible to members only")
+	ErrPostingRestricted   = errors.New("posting is restricted in this forum")
+	ErrThreadLocked        = errors.New("thread is locked")
+	ErrThreadClosed        = errors.New("thread is closed")
+	ErrInvalidState        = errors.New("invalid thread state")
+	ErrInvalidTag          = errors.New("invalid tag")
+	ErrInvalidRanking      = errors.New("invalid ranker or window")
+	ErrInvalidMerge        = errors.New("thread can't be merged into itself")
+	ErrMergePoll           = errors.New("thread with a poll can't be merged")
+	ErrInvalidSplit        = errors.New("split thread needs a title")
+	ErrInvalidSlug         = errors.New("invalid slug")
+	ErrRevisionNotFound    = errors.New("revision not found")
+	ErrInvalidPoll         = errors.New("invalid poll")
+	ErrInvalidBallot       = errors.New("invalid ballot")
+	ErrPollNotFound        = errors.New("thread has no poll")
+	ErrPollClosed          = errors.New("poll is closed")
+	ErrInvalidVote         = errors.New("voice must be -1, 0 or 1")
 )
@@ -0,0 +1,132 @@
+// Package slug makes URL slugs out of titles and checks the ones users send.
+//
+// A slug is 1 to 128 characters out of the ASCII letters, digits, '-' and
+// '_', and is not all digits so it can't be mistaken for a numeric id.
+// Generated slugs are lowercase words joined by '-'; Cyrillic is
+// transliterated and accents are dropped.
+package slug
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MaxLength = 128
+
+	// MaxAttempts bounds the retries when a generated slug, checked free
+	// with a FreeSlug lookup, is taken by a concurrent insert.
+	MaxAttempts = 3
+
+	// generatedLength caps slugs made from long titles, cut at a word end.
+	generatedLength = 64
+)
+
+var (
+	pattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	digits  = regexp.MustCompile(`^[0-9]+$`)
+)
+
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+func Valid(s string) bool {
+	return len(s) <= MaxLength && pattern.MatchString(s) && !digits.MatchString(s)
+}
+
+// Make turns a title into a slug, or returns fallback when nothing of the
+// title survives.
+func Make(title, fallback string) string {
+	var words []string
+	var word strings.Builder
+	for _, r := range norm.NFC.String(strings.ToLower(title)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		// Cyrillic is looked up composed, since й, ё and ї decompose into
+		// letters with their own, different transliteration.
+		part, ok := cyrillic[r]
+		if !ok {
+			part = stripMarks(r)
+		}
+		if !ok && part == "" {
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			continue
+		}
+		word.WriteString(part)
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	var b strings.Builder
+	for _, w := range words {
+		if b.Len() == 0 {
+			b.WriteString(w)
+			continue
+		}
+		if b.Len()+1+len(w) > generatedLength {
+			break
+		}
+		b.WriteByte('-')
+		b.WriteString(w)
+	}
+
+	res := b.String()
+	if len(res) > generatedLength {
+		res = res[:generatedLength]
+	}
+	if res == "" {
+		return fallback
+	}
+	if digits.MatchString(res) {
+		return fallback + "-" + res
+	}
+	return res
+}
+
+// stripMarks decomposes r and keeps its ASCII letters and digits, so é
+// becomes e; anything else is "".
+func stripMarks(r rune) string {
+	var b strings.Builder
+	for _, c := range norm.NFD.String(string(r)) {
+		if unicode.Is(unicode.Mn, c) {
+			continue
+		}
+		if c >= unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			return ""
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Next returns base, or base with the lowest "-N" suffix, N >= 2, that is
+// not among taken. Slugs compare case-insensitively.
+func Next(base string, taken []string) string {
+	used := make(map[string]bool, len(taken))
+	for _, s := range taken {
+		used[strings.ToLower(s)] = true
+	}
+	if !used[strings.ToLower(base)] {
+		return base
+	}
+	for n := 2; ; n++ {
+		candidate := base + "-" + strconv.Itoa(n)
+		if !used[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Go 1.22 release notes ", "go-1-22-release-notes"},
+		{"Café déjà vu", "cafe-deja-vu"},
+		{"Привет мир", "privet-mir"},
+		{"Йошкар-Ола", "yoshkar-ola"},
+		{"ёлка", "yolka"},
+		{"Їжак", "yizhak"},
+		{"Щука съела ёжика", "shchuka-sela-yozhika"},
+		// Decomposed input is composed before the lookup.
+		{"ёлка", "yolka"},
+		{"2024", "thread-2024"},
+		{"1 2 3", "1-2-3"},
+		{"!!!", "thread"},
+		{"", "thread"},
+	}
+	for _, tt := range tests {
+		if got := Make(tt.title, "thread"); got != tt.want {
+			t.Errorf("Make(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMakeCutsAtWordEnd(t *testing.T) {
+	title := strings.Repeat("word ", 13) + "overflow"
+	got := Make(title, "thread")
+	if len(got) > generatedLength {
+		t.Fatalf("len(%q) = %d, want at most %d", got, len(got), generatedLength)
+	}
+	// 13 "word"s and their dashes fill the 64 characters exactly.
+	if want := strings.TrimSuffix(strings.Repeat("word-", 13), "-"); got != want {
+		t.Fatalf("Make(%q) = %q, want %q", title, got, want)
+	}
+	if !Valid(got) {
+		t.Fatalf("Make(%q) = %q is not a valid slug", title, got)
+	}
+}
+
+func TestMakeCutsLongWord(t *testing.T) {
+	got := Make(strings.Repeat("a", 100)+" tail", "thread")
+	if got != strings.Repeat("a", generatedLength) {
+		t.Fatalf("Make(long word) = %q, want %d a's", got, generatedLength)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"go-news_2", true},
+		{"Go", true},
+		{"42", false},
+		{"", false},
+		{"with space", false},
+		{"ёлка", false},
+		{strings.Repeat("a", MaxLength), true},
+		{strings.Repeat("a", MaxLength+1), false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.slug); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.slug, got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		base  string
+		taken []string
+		want  string
+	}{
+		{"go", nil, "go"},
+		{"go", []string{"Go"}, "go-2"},
+		{"go", []string{"go", "go-2", "go-4"}, "go-3"},
+	}
+	for _, tt := range tests {
+		if got := Next(tt.base, tt.taken); got != tt.want {
+			t.Errorf("Next(%q, %v) = %q, want %q", tt.base, tt.taken, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package timeseries
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	Hour = "hour"
+	Day  = "day"
+	Week = "week"
+)
+
+// MaxBuckets bounds how many points one request may ask for.
+const MaxBuckets = 1000
+
+// DefaultBuckets is how far back a range without from reaches.
+const DefaultBuckets = 30
+
+var ErrInvalidRange = errors.New("bucket must be hour, day or week, from must precede to and the range may span at most 1000 buckets")
+
+var steps = map[string]time.Duration{
+	Hour: time.Hour,
+	Day:  24 * time.Hour,
+	Week: 7 * 24 * time.Hour,
+}
+
+// Parse reads RFC 3339 bounds; an empty bound stays zero.
+func Parse(from, to string) (time.Time, time.Time, error) {
+	var res [2]time.Time
+	for i, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidRange
+		}
+		res[i] = t
+	}
+	return res[0], res[1], nil
+}
+
+// Window resolves a requested range. A zero to means now and a zero from
+// means DefaultBuckets buckets before to; the range is rejected when the
+// bucket is unknown, the range is empty or it spans more than MaxBuckets.
+func Window(from, to time.Time, bucket string, now time.Time) (time.Time, time.Time, error) {
+	step, ok := steps[bucket]
+	if !ok {
+		return time.Time{}, time.Time{}, ErrInvalidRange
+	}
+
+	if to.IsZero() {
+		to = now
+	}
+	if from.IsZero() {
+		from = to.Add(-DefaultBuckets * step)
+	}
+	if !from.Before(to) || to.Sub(from)/step >= MaxBuckets {
+		return time.Time{}, time.Time{}, ErrInvalidRange
+	}
+	return from, to, nil
+}